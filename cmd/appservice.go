@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var registrationFile string
+
+// appserviceCmd represents the appservice command
+var appserviceCmd = &cobra.Command{
+	Use:   "appservice",
+	Short: "Generates the Application Service registration",
+	Long: `Generates the Application Service registration file for the homeserver.
+Missing tokens are generated and must be copied in the configuration.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		as := &conf.AppService
+		if as.ID == "" {
+			as.ID = "matrix-notifier"
+		}
+		for _, t := range []*string{&as.ASToken, &as.HSToken} {
+			if *t == "" {
+				*t = randomToken()
+			}
+		}
+		localpart := strings.SplitN(strings.TrimPrefix(as.UserID, "@"), ":", 2)[0]
+		if localpart == "" {
+			logger.Fatalln("AppService: missing user ID")
+		}
+		type namespace struct {
+			Exclusive bool   `yaml:"exclusive"`
+			Regex     string `yaml:"regex"`
+		}
+		reg := struct {
+			ID              string `yaml:"id"`
+			URL             string `yaml:"url"`
+			ASToken         string `yaml:"as_token"`
+			HSToken         string `yaml:"hs_token"`
+			SenderLocalpart string `yaml:"sender_localpart"`
+			RateLimited     bool   `yaml:"rate_limited"`
+			Namespaces      struct {
+				Users   []namespace `yaml:"users"`
+				Aliases []namespace `yaml:"aliases"`
+				Rooms   []namespace `yaml:"rooms"`
+			} `yaml:"namespaces"`
+		}{
+			ID:              as.ID,
+			URL:             as.URL,
+			ASToken:         as.ASToken,
+			HSToken:         as.HSToken,
+			SenderLocalpart: localpart,
+		}
+		reg.Namespaces.Users = []namespace{{Exclusive: true, Regex: "@" + regexp.QuoteMeta(localpart) + ":.*"}}
+		reg.Namespaces.Aliases, reg.Namespaces.Rooms = []namespace{}, []namespace{}
+
+		var w io.Writer = os.Stdout
+		if registrationFile != "" {
+			f, err := os.Create(registrationFile)
+			if err != nil {
+				logger.Fatalln("Registration:", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := yaml.NewEncoder(w).Encode(reg); err != nil {
+			logger.Fatalln("Registration:", err)
+		}
+	},
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logger.Fatalln("Token:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func init() {
+	RootCmd.AddCommand(appserviceCmd)
+	appserviceCmd.Flags().StringVarP(&registrationFile, "output", "o", "", "registration file (default is stdout)")
+}
@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	gorp "gopkg.in/gorp.v2"
 
 	"github.com/securityfirst/matrix-notifier/database"
+	"github.com/securityfirst/matrix-notifier/sender"
+	"github.com/securityfirst/matrix-notifier/server"
 )
 
 type config struct {
@@ -28,6 +31,44 @@ type config struct {
 	Server struct {
 		Address string
 		Debug   bool
+		Origins []string
+	}
+	AppService struct {
+		ID      string
+		URL     string
+		UserID  string
+		ASToken string
+		HSToken string
+	}
+	Mirror struct {
+		Enabled   bool
+		EventType string
+		Interval  time.Duration
+	}
+	SMTP struct {
+		Address  string
+		Username string
+		Password string
+		From     string
+	}
+	Invite struct {
+		TTL  time.Duration
+		Link string
+		Log  bool // writes the invites to the standard output without SMTP, for local use
+	}
+	Schedule struct {
+		Interval time.Duration
+	}
+	Retention struct {
+		Lifetime time.Duration
+		Interval time.Duration
+	}
+	Auth struct {
+		TokenTTL        time.Duration
+		InvalidTokenTTL time.Duration
+		CacheSize       int
+		NoQueryToken    bool
+		LevelTTL        time.Duration
 	}
 }
 
@@ -37,6 +78,36 @@ func (c config) Init() {
 	}
 }
 
+// Options returns the Server options.
+func (c config) Options() []server.Option {
+	opts := []server.Option{
+		server.WithTokenCache(c.Auth.TokenTTL, c.Auth.InvalidTokenTTL, c.Auth.CacheSize),
+		server.WithLevelCache(c.Auth.LevelTTL),
+		server.WithScheduler(c.Schedule.Interval),
+		server.WithRetention(c.Retention.Lifetime, c.Retention.Interval),
+	}
+	if c.Auth.NoQueryToken {
+		opts = append(opts, server.WithoutQueryToken())
+	}
+	if len(c.Server.Origins) != 0 {
+		opts = append(opts, server.WithOrigins(c.Server.Origins...))
+	}
+	if as := c.AppService; as.ASToken != "" {
+		opts = append(opts, server.WithAppService(as.UserID, as.ASToken, as.HSToken))
+	}
+	var s sender.Sender
+	if c.SMTP.Address != "" {
+		s = sender.SMTP{Address: c.SMTP.Address, Username: c.SMTP.Username, Password: c.SMTP.Password, From: c.SMTP.From}
+	} else if c.Invite.Log {
+		s = sender.Log{Logger: log.New(os.Stdout, "[invite] ", log.LstdFlags)}
+	}
+	opts = append(opts, server.WithInvites(s, c.Invite.TTL, c.Invite.Link))
+	if m := c.Mirror; m.Enabled {
+		opts = append(opts, server.WithMirror(m.EventType, m.Interval))
+	}
+	return opts
+}
+
 func (c config) GetDB() (*gorp.DbMap, error) {
 	db, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s/%s?%s",
 		c.DB.Username, c.DB.Password, c.DB.Host, c.DB.Database, c.DB.Options))
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+	"github.com/spf13/cobra"
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Deletes the expired notifications",
+	Long: `Deletes the notifications past their expiry time, or older than the retention
+of their organisation or the configured default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := conf.GetDB()
+		if err != nil {
+			logger.Fatalln("DB:", err)
+		}
+		n, err := database.Purge(db, time.Now(), conf.Retention.Lifetime)
+		if err != nil {
+			logger.Fatalln("Purge:", err)
+		}
+		logger.Printf("Purged %d notifications", n)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(purgeCmd)
+}
@@ -21,7 +21,7 @@ var runCmd = &cobra.Command{
 		if err != nil {
 			logger.Fatalln("DB:", err)
 		}
-		s := server.NewServer(conf.Server.Address, conf.Matrix.Address, db)
+		s := server.NewServer(conf.Server.Address, conf.Matrix.Address, db, conf.Options()...)
 		logger.Println("Listening on:", conf.Server.Address)
 		go func() {
 			if err := s.Run(); err != nil && err != http.ErrServerClosed {
@@ -29,7 +29,7 @@ var runCmd = &cobra.Command{
 			}
 		}()
 
-		quit := make(chan os.Signal)
+		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, os.Interrupt)
 		<-quit
 
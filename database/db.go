@@ -38,7 +38,10 @@ type DB interface {
 
 // InitDBMap initializes the DbMap and creates the tables.
 func InitDBMap(d *gorp.DbMap) error {
-	for _, t := range []table{Org{}, Notification{}, NotificationUser{}} {
+	for _, t := range []table{
+		Org{}, Notification{}, NotificationRead{}, NotificationEdit{}, NotificationRecipient{},
+		Mirror{}, Invite{}, PanicEvent{},
+	} {
 		table := d.AddTableWithName(t, t.name())
 		for i, s := range t.unique() {
 			switch {
@@ -51,7 +54,80 @@ func InitDBMap(d *gorp.DbMap) error {
 			}
 		}
 	}
-	return d.CreateTablesIfNotExists()
+	if err := d.CreateTablesIfNotExists(); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := d.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations update existing tables, they are executed at every start and must be idempotent.
+var migrations = []string{
+	`update notifications set type = 'poll' where type = 'poll  '`,
+	`alter table notifications add column if not exists archived_at timestamp with time zone`,
+	// the last read time of a user is copied to the notifications of the Orgs before it, as room membership
+	// is not stored and visibility is checked when reading, the old table is renamed to be dropped later
+	`do $$ begin
+		if to_regclass('notification_users') is not null then
+			insert into notification_reads (notification_id, user_id, read_at)
+				select n.id, u.user_id, u.last_read from notifications n
+				join organisations o on o.room_id = n.room_id
+				join notification_users u on n.created_at <= u.last_read
+				on conflict do nothing;
+			alter table notification_users rename to notification_users_old;
+		end if;
+	end $$`,
+	`alter table notifications add column if not exists send_at timestamp with time zone`,
+	`alter table notifications add column if not exists expires_at timestamp with time zone`,
+	`alter table organisations add column if not exists retention integer not null default 0`,
+	`alter table notifications add column if not exists collapse_key text`,
+	`update notifications set collapse_key = coalesce(content::json->>'collapse_key', '') where collapse_key is null`,
+	`create index if not exists notifications_collapse_key on notifications (room_id, collapse_key, id)
+		where collapse_key <> ''`,
+	`alter table notifications add column if not exists edited_at timestamp with time zone`,
+	`alter table notifications add column if not exists retracted boolean not null default false`,
+	`create index if not exists notification_edits_notification_id on notification_edits (notification_id)`,
+	`alter table notifications add column if not exists min_level integer`,
+	`alter table notifications add column if not exists max_level integer`,
+	`alter table notifications add column if not exists ref_id text`,
+	`update notifications set ref_id = coalesce(content::json->>'ref_id', '') where ref_id is null`,
+	`create index if not exists notifications_ref_id on notifications (ref_id) where ref_id <> ''`,
+	// only the newest vote of a user on a poll is kept before the unique index
+	`update notifications n set archived_at = now()
+		where type = 'vote' and not retracted and archived_at is null and exists (select 1 from notifications v
+			where v.ref_id = n.ref_id and v.user_id = n.user_id and v.id > n.id
+			and v.type = 'vote' and not v.retracted and v.archived_at is null)`,
+	`create unique index if not exists notifications_vote on notifications (ref_id, user_id)
+		where type = 'vote' and not retracted and archived_at is null`,
+	`alter table notifications add column if not exists closes_at timestamp with time zone`,
+	`alter table notifications add column if not exists closed_at timestamp with time zone`,
+	`alter table notifications add column if not exists anonymous boolean not null default false`,
+	`create index if not exists notifications_closes_at on notifications (closes_at) where closed_at is null`,
+	`alter table organisations add column if not exists escalation integer[]`,
+	// the column is created as text with the table, the arrays are compared as integers
+	`do $$ begin
+		if (select data_type from information_schema.columns
+			where table_name = 'organisations' and column_name = 'escalation') = 'text' then
+			alter table organisations alter column escalation type integer[] using escalation::integer[];
+		end if;
+	end $$`,
+	`alter table notifications add column if not exists acked_at timestamp with time zone`,
+	`alter table notifications add column if not exists acked_by text not null default ''`,
+	`alter table notifications add column if not exists resolved_at timestamp with time zone`,
+	`alter table notifications add column if not exists escalation integer not null default 0`,
+	`create index if not exists notifications_panic on notifications (created_at)
+		where type = 'panic' and acked_at is null and resolved_at is null`,
+	`create index if not exists panic_events_notification_id on panic_events (notification_id)`,
+	`create unique index if not exists panic_events_delivered on panic_events (notification_id, user_id)
+		where type = 'delivered'`,
+	`alter table notification_mirrors add column if not exists update_id text not null default ''`,
+	`alter table notification_mirrors add column if not exists room_id text not null default ''`,
+	`update notification_mirrors m set room_id = n.room_id from notifications n
+		where n.id = m.notification_id and m.room_id = ''`,
 }
 
 // Get returns the Record with the selected key.
@@ -97,13 +173,19 @@ func ListOrgs(d DB, ids ...string) ([]*Org, error) {
 	return o, nil
 }
 
-// ListNotifications returns a list of notifications since the specified time.
-// levels is a power level per room, rules is the level per type
-func ListNotifications(d DB, since time.Time, userID string, levels, rules map[string]int) ([]*Notification, error) {
-	type N struct {
-		Notification
-		Read bool `db:"read"`
+// visible filters the notifications that a user can see.
+// levels is a power level per room, rules is the level per type.
+// Below the level of answers, a user sees only its own answers and the answers to its questions.
+func visible(userID string, levels, rules map[string]int) sq.Sqlizer {
+	return visibleAs("n", userID, levels, rules)
+}
+
+// visibleAs is visible for the notifications table with another alias.
+func visibleAs(alias, userID string, levels, rules map[string]int) sq.Sqlizer {
+	if len(levels) == 0 {
+		return sq.Expr("false")
 	}
+	col := func(name string) string { return alias + "." + name }
 	filter := make(sq.Or, 0, len(levels))
 	for room, lvl := range levels {
 		var keys []string
@@ -112,17 +194,126 @@ func ListNotifications(d DB, since time.Time, userID string, levels, rules map[s
 				keys = append(keys, t)
 			}
 		}
-		filter = append(filter, sq.Eq{
-			"n.room_id": room,
-			"n.type":    keys,
+		filter = append(filter, sq.And{
+			sq.Eq{col("room_id"): room, col("type"): keys},
+			sq.Or{sq.Eq{col("min_level"): nil}, sq.LtOrEq{col("min_level"): lvl}, sq.Eq{col("user_id"): userID}},
+			sq.Or{sq.Eq{col("max_level"): nil}, sq.GtOrEq{col("max_level"): lvl}, sq.Eq{col("user_id"): userID}},
 		})
 	}
-	query, args, err := psql.Select(`n.*, (last_read is not null and last_read > n.created_at) as read`).From(Notification{}.name()+` n`).
-		LeftJoin(NotificationUser{}.name()+` u on (u.user_id = ?)`, userID).Where(sq.And{
-		sq.Gt{"n.created_at": since}, filter}).ToSql()
+	rooms := make([]string, 0, len(levels))
+	for room := range levels {
+		rooms = append(rooms, room)
+	}
+	filter = append(filter, sq.And{
+		sq.Eq{col("room_id"): rooms, col("type"): "answer"},
+		sq.Or{
+			sq.Eq{col("user_id"): userID},
+			sq.Expr(`exists (select 1 from `+Notification{}.name()+` q where q.id = `+col("ref_id")+` and q.user_id = ?)`, userID),
+		},
+	})
+	recipients := `select 1 from ` + NotificationRecipient{}.name() + ` r where r.notification_id = ` + col("id")
+	return sq.And{
+		sq.Eq{col("archived_at"): nil, col("send_at"): nil},
+		sq.Or{sq.Eq{col("anonymous"): false}, sq.Eq{col("user_id"): userID}},
+		sq.Or{sq.Eq{col("expires_at"): nil}, sq.Expr(col("expires_at") + " > now()")},
+		sq.Or{
+			sq.Eq{col("user_id"): userID},
+			sq.Expr(`not exists (` + recipients + `)`),
+			sq.Expr(`exists (`+recipients+` and r.user_id = ?)`, userID),
+		},
+		filter,
+	}
+}
+
+// Page selects a slice of an ordered list, starting after the From key.
+// A zero Limit means no limit.
+type Page struct {
+	From     string
+	Limit    uint64
+	Backward bool
+
+	// ByPriority orders by priority first, starting after FromPriority.
+	ByPriority   bool
+	FromPriority Priority
+}
+
+// NotificationFilter selects the notifications that a user can see.
+type NotificationFilter struct {
+	UserID     string
+	Since      time.Time      // created or edited after
+	Levels     map[string]int // power level per room
+	Rules      map[string]int // minimum level per type
+	Superseded bool           // includes the notifications replaced by a newer one with the same collapse key
+
+	MinPriority *Priority
+
+	// Only the notifications of a type or referencing a notification, if set.
+	Type  string
+	RefID string
+}
+
+// superseded is true if a newer notification, that the user can see, has the same collapse key.
+func superseded(userID string, levels, rules map[string]int) (string, []interface{}, error) {
+	where, args, err := visibleAs("s", userID, levels, rules).ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return `n.collapse_key <> '' and exists (select 1 from ` + Notification{}.name() + ` s
+	where s.room_id = n.room_id and s.collapse_key = n.collapse_key and s.id > n.id and ` + where + `)`, args, nil
+}
+
+// ListNotifications returns a page of notifications, ordered by ID.
+func ListNotifications(d DB, f NotificationFilter, p Page) ([]*Notification, error) {
+	type N struct {
+		Notification
+		Read       bool `db:"read"`
+		Superseded bool `db:"superseded"`
+	}
+	sup, supArgs, err := superseded(f.UserID, f.Levels, f.Rules)
 	if err != nil {
 		return nil, err
 	}
+	filter := sq.And{sq.Or{sq.Gt{"n.created_at": f.Since}, sq.Gt{"n.edited_at": f.Since}}, visible(f.UserID, f.Levels, f.Rules)}
+	if !f.Superseded {
+		filter = append(filter, sq.Expr(`not (`+sup+`)`, supArgs...))
+	}
+	if f.MinPriority != nil {
+		filter = append(filter, sq.GtOrEq{"n.priority": *f.MinPriority})
+	}
+	eq := sq.Eq{}
+	for col, v := range map[string]string{"n.type": f.Type, "n.ref_id": f.RefID} {
+		if v != "" {
+			eq[col] = v
+		}
+	}
+	if len(eq) != 0 {
+		filter = append(filter, eq)
+	}
+	// priority is descending when ID is ascending
+	var after, before sq.Sqlizer = sq.Gt{"n.id": p.From}, sq.Lt{"n.priority": p.FromPriority}
+	asc, desc := "", " desc"
+	if p.Backward {
+		after, before, asc, desc = sq.Lt{"n.id": p.From}, sq.Gt{"n.priority": p.FromPriority}, desc, asc
+	}
+	order := []string{"n.id" + asc}
+	if p.ByPriority {
+		order = append([]string{"n.priority" + desc}, order...)
+	}
+	switch {
+	case p.From == "":
+	case p.ByPriority:
+		filter = append(filter, sq.Or{before, sq.And{sq.Eq{"n.priority": p.FromPriority}, after}})
+	default:
+		filter = append(filter, after)
+	}
+	q := psql.Select(`n.*`).Column(`exists (select 1 from `+NotificationRead{}.name()+
+		` r where r.notification_id = n.id and r.user_id = ?) as read`, f.UserID).
+		Column(`(`+sup+`) as superseded`, supArgs...).
+		From(Notification{}.name() + ` n`).Where(filter).OrderBy(order...)
+	if p.Limit > 0 {
+		q = q.Limit(p.Limit)
+	}
+	query, args, err := q.ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -134,22 +325,436 @@ func ListNotifications(d DB, since time.Time, userID string, levels, rules map[s
 	for i := range list {
 		v := list[i].(*N)
 		n[i] = &v.Notification
-		n[i].Read = v.Read
+		n[i].Read, n[i].Superseded = v.Read, v.Superseded
 	}
 	return n, nil
 }
 
-// MarkAsRead upserts read time for a user
-func MarkAsRead(db DB, userID string, t time.Time) error {
-	v, err := db.Get(&NotificationUser{}, userID)
+// MarkAsRead marks as read all the notifications that a user can see.
+// levels is a power level per room, rules is the level per type
+func MarkAsRead(d DB, userID string, t time.Time, levels, rules map[string]int) error {
+	sel := psql.Select("n.id").Column("?::text", userID).Column("?::timestamptz", t).
+		From(Notification{}.name() + ` n`).Where(visible(userID, levels, rules))
+	query, args, err := psql.Insert(NotificationRead{}.name()).Columns("notification_id", "user_id", "read_at").
+		Select(sel).Suffix("on conflict do nothing").ToSql()
 	if err != nil {
 		return err
 	}
-	n := NotificationUser{UserID: userID, LastRead: t}
-	if v == nil {
-		err = db.Insert(&n)
-	} else {
-		_, err = db.Update(&n)
+	_, err = d.Exec(query, args...)
+	return err
+}
+
+// MarkNotificationRead marks a notification as read for a user.
+func MarkNotificationRead(d DB, id, userID string, t time.Time) error {
+	_, err := d.Exec(`insert into `+NotificationRead{}.name()+` (notification_id, user_id, read_at) `+
+		`values ($1, $2, $3) on conflict do nothing`, id, userID, t)
+	return err
+}
+
+// MarkNotificationUnread marks a notification as unread for a user.
+func MarkNotificationUnread(d DB, id, userID string) error {
+	_, err := d.Delete(&NotificationRead{NotificationID: id, UserID: userID})
+	return err
+}
+
+// DueNotifications returns the scheduled Notifications due before t.
+func DueNotifications(d DB, t time.Time, limit uint64) ([]*Notification, error) {
+	return selectNotifications(d, psql.Select("*").From(Notification{}.name()).
+		Where(sq.And{sq.LtOrEq{"send_at": t}, sq.Eq{"archived_at": nil}}).OrderBy("send_at").Limit(limit))
+}
+
+// ListScheduled returns the scheduled Notifications of a User.
+func ListScheduled(d DB, userID string) ([]*Notification, error) {
+	return selectNotifications(d, psql.Select("*").From(Notification{}.name()).
+		Where(sq.And{sq.NotEq{"send_at": nil}, sq.Eq{"user_id": userID, "archived_at": nil}}).OrderBy("send_at"))
+}
+
+func selectNotifications(d DB, q sq.SelectBuilder) ([]*Notification, error) {
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	list, err := d.Select(Notification{}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	n := make([]*Notification, len(list))
+	for i := range list {
+		n[i] = list[i].(*Notification)
+	}
+	return n, nil
+}
+
+// PublishNotification publishes a scheduled Notification with a new ID and creation time,
+// returns sql.ErrNoRows if it's not scheduled.
+func PublishNotification(d DB, n *Notification, id string, t time.Time) error {
+	err := updateScheduled(d, n.ID, map[string]interface{}{"id": id, "created_at": t, "send_at": nil}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`update `+NotificationRecipient{}.name()+` set notification_id = $1 where notification_id = $2`, id, n.ID)
+	if err == nil {
+		n.ID, n.CreatedAt, n.SendAt = id, t, nil
+	}
+	return err
+}
+
+// RescheduleNotification changes the time of a scheduled Notification of a User,
+// returns sql.ErrNoRows if there's none.
+func RescheduleNotification(d DB, id, userID string, t time.Time) error {
+	return updateScheduled(d, id, map[string]interface{}{"send_at": t}, sq.Eq{"user_id": userID})
+}
+
+// CancelNotification deletes a scheduled Notification of a User, returns sql.ErrNoRows if there's none.
+func CancelNotification(d DB, id, userID string) error {
+	err := checkAffected(d, psql.Delete(Notification{}.name()).
+		Where(sq.And{sq.Eq{"id": id, "user_id": userID}, sq.NotEq{"send_at": nil}}))
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`delete from `+NotificationRecipient{}.name()+` where notification_id = $1`, id)
+	return err
+}
+
+// GetVote returns the current Vote of a User for a Poll, nil if there's none.
+func GetVote(d DB, pollID, userID string) (*Notification, error) {
+	list, err := selectNotifications(d, psql.Select("*").From(Notification{}.name()).Where(sq.Eq{
+		"ref_id": pollID, "user_id": userID, "type": "vote", "retracted": false, "archived_at": nil,
+	}))
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// Tally returns the number of Votes per choice of a Poll, in the order of the choices,
+// and the number of voters.
+func Tally(d DB, poll *Notification) (*PollResults, error) {
+	var rows []struct {
+		Value string `db:"value"`
+		Count int    `db:"count"`
+	}
+	where := ` where ref_id = $1 and type = 'vote' and not retracted and archived_at is null`
+	_, err := d.Select(&rows, `select v as value, count(*) as count from `+Notification{}.name()+`,
+		json_array_elements_text(coalesce(content::json->'values', json_build_array(content::json->>'value'))) v`+
+		where+` group by 1`, poll.ID)
+	if err != nil {
+		return nil, err
+	}
+	tally := make(map[string]int, len(rows))
+	for _, r := range rows {
+		tally[r.Value] = r.Count
+	}
+	r := PollResults{PollID: poll.ID, Results: []ChoiceCount{}}
+	if r.Voters, err = d.SelectInt(`select count(*) from `+Notification{}.name()+where, poll.ID); err != nil {
+		return nil, err
+	}
+	if poll.Content != nil {
+		for _, c := range poll.Content.Choices {
+			r.Results = append(r.Results, ChoiceCount{Choice: c, Count: tally[c.Value]})
+		}
+	}
+	return &r, nil
+}
+
+// DuePanics returns the unacknowledged, unexpired, Panics that reached the next escalation delay of their Org before t.
+func DuePanics(d DB, t time.Time, limit uint64) ([]*Notification, error) {
+	return selectNotifications(d, psql.Select("n.*").From(Notification{}.name()+` n`).
+		Join(Org{}.name()+` o on o.room_id = n.room_id`).Where(sq.And{
+		sq.Eq{"n.type": "panic", "n.acked_at": nil, "n.resolved_at": nil, "n.send_at": nil, "n.archived_at": nil,
+			"n.retracted": false},
+		sq.Or{sq.Eq{"n.expires_at": nil}, sq.Gt{"n.expires_at": t}},
+		sq.Expr(`n.escalation < coalesce(array_length(o.escalation, 1), 0)`),
+		sq.Expr(`n.created_at + o.escalation[n.escalation + 1] * interval '1 second' <= ?`, t),
+	}).OrderBy("n.created_at").Limit(limit))
+}
+
+// EscalatePanic records an escalation step of an unacknowledged Panic,
+// it returns sql.ErrNoRows if the Panic was acknowledged or escalated already.
+func EscalatePanic(d DB, n *Notification, p Priority) error {
+	if err := checkAffected(d, psql.Update(n.name()).Set("escalation", n.Escalation+1).Set("priority", p).
+		Where(sq.Eq{"id": n.ID, "escalation": n.Escalation, "acked_at": nil, "resolved_at": nil})); err != nil {
+		return err
+	}
+	n.Escalation, n.Priority = n.Escalation+1, p
+	return nil
+}
+
+// AckPanic acknowledges a Panic, it returns sql.ErrNoRows if it was acknowledged already.
+func AckPanic(d DB, n *Notification, userID string, t time.Time) error {
+	if err := checkAffected(d, psql.Update(n.name()).Set("acked_at", t).Set("acked_by", userID).
+		Where(sq.Eq{"id": n.ID, "acked_at": nil})); err != nil {
+		return err
+	}
+	n.AckedAt, n.AckedBy = &t, userID
+	return nil
+}
+
+// ResolvePanic resolves a Panic, it returns sql.ErrNoRows if it was resolved already.
+func ResolvePanic(d DB, n *Notification, t time.Time) error {
+	if err := checkAffected(d, psql.Update(n.name()).Set("resolved_at", t).
+		Where(sq.Eq{"id": n.ID, "resolved_at": nil})); err != nil {
+		return err
+	}
+	n.ResolvedAt = &t
+	return nil
+}
+
+// AddDelivery records the delivery of a Panic to a user, once.
+func AddDelivery(d DB, e *PanicEvent) error {
+	_, err := d.Exec(`insert into `+e.name()+` (id, notification_id, type, user_id, created_at)
+		values ($1, $2, $3, $4, $5) on conflict do nothing`, e.ID, e.NotificationID, EventDelivered, e.UserID, e.CreatedAt)
+	return err
+}
+
+// PanicTimeline returns the events of a Panic, in order.
+func PanicTimeline(d DB, id string) ([]*PanicEvent, error) {
+	var list []*PanicEvent
+	_, err := d.Select(&list, `select * from `+PanicEvent{}.name()+` where notification_id = $1
+		order by created_at, id`, id)
+	return list, err
+}
+
+// Voters returns the users that voted in a Poll.
+func Voters(d DB, pollID string) ([]string, error) {
+	var list []string
+	_, err := d.Select(&list, `select user_id from `+Notification{}.name()+` where ref_id = $1 and type = 'vote'
+		and not retracted and archived_at is null order by user_id`, pollID)
+	return list, err
+}
+
+// DuePolls returns the open Polls with a closing time before t.
+func DuePolls(d DB, t time.Time, limit uint64) ([]*Notification, error) {
+	return selectNotifications(d, psql.Select("*").From(Notification{}.name()).Where(sq.And{
+		sq.LtOrEq{"closes_at": t},
+		sq.Eq{"closed_at": nil, "send_at": nil, "archived_at": nil, "retracted": false},
+	}).OrderBy("closes_at").Limit(limit))
+}
+
+// ClosePoll closes an open Poll, it returns sql.ErrNoRows if it was closed already.
+func ClosePoll(d DB, n *Notification, t time.Time) error {
+	if err := checkAffected(d, psql.Update(n.name()).Set("closed_at", t).
+		Where(sq.Eq{"id": n.ID, "closed_at": nil})); err != nil {
+		return err
+	}
+	n.ClosedAt = &t
+	return nil
+}
+
+// EditNotification saves the content, the edit time, the retraction and the closing time of a Notification,
+// leaving the other fields as they are. It returns sql.ErrNoRows if it was retracted already.
+func EditNotification(d DB, n *Notification) error {
+	return checkAffected(d, psql.Update(n.name()).Set("content", n.Content).Set("edited_at", n.EditedAt).
+		Set("retracted", n.Retracted).Set("closes_at", n.ClosesAt).
+		Where(sq.Eq{"id": n.ID, "retracted": false}))
+}
+
+// Recipients returns the Recipients of a Notification.
+func Recipients(d DB, id string) ([]string, error) {
+	var list []string
+	_, err := d.Select(&list, `select user_id from `+NotificationRecipient{}.name()+
+		` where notification_id = $1 order by user_id`, id)
+	return list, err
+}
+
+func updateScheduled(d DB, id string, values map[string]interface{}, filter sq.Sqlizer) error {
+	where := sq.And{sq.Eq{"id": id}, sq.NotEq{"send_at": nil}}
+	if filter != nil {
+		where = append(where, filter)
+	}
+	return checkAffected(d, psql.Update(Notification{}.name()).SetMap(values).Where(where))
+}
+
+// checkAffected executes the query, returns sql.ErrNoRows if no row is affected.
+func checkAffected(d DB, q sq.Sqlizer) error {
+	query, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := d.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// Purge deletes the Notifications expired at t, or older than the retention of their Org,
+// with their read state and Mirrors. A zero retention keeps the Notifications of Orgs without one.
+// The sent Mirrors are kept to redact their events.
+func Purge(d DB, t time.Time, retention time.Duration) (int64, error) {
+	var defaultRetention interface{}
+	if retention > 0 {
+		defaultRetention = int64(retention / time.Second)
+	}
+	ids := `notification_id in (select id from purged)`
+	return d.SelectInt(`with purged as (delete from `+Notification{}.name()+` n where n.expires_at <= $1
+		or n.send_at is null and n.created_at <= $1 - coalesce((select nullif(o.retention, 0) from `+
+		Org{}.name()+` o where o.room_id = n.room_id), $2) * interval '1 second' returning n.id),
+		r as (delete from `+NotificationRead{}.name()+` where `+ids+`),
+		e as (delete from `+NotificationEdit{}.name()+` where `+ids+`),
+		t as (delete from `+NotificationRecipient{}.name()+` where `+ids+`),
+		m as (delete from `+Mirror{}.name()+` where `+ids+` and event_id = ''),
+		u as (update `+Mirror{}.name()+` set update_id = 'purge.' || notification_id, attempts = 0, next_attempt = $1
+			where `+ids+` and event_id <> ''),
+		p as (delete from `+PanicEvent{}.name()+` where `+ids+`)
+		select count(*) from purged`, t, defaultRetention)
+}
+
+// LastMirror returns the latest sent Mirror of a Notification with the same collapse key, nil if there's none.
+func LastMirror(d DB, n *Notification) (*Mirror, error) {
+	if n.CollapseKey == "" {
+		return nil, nil
+	}
+	query, args, err := psql.Select("m.*").From(Mirror{}.name() + ` m`).
+		Join(Notification{}.name() + ` n on n.id = m.notification_id`).
+		Where(sq.And{sq.Eq{"n.room_id": n.RoomID, "n.collapse_key": n.CollapseKey}, sq.Lt{"n.id": n.ID},
+			sq.NotEq{"m.event_id": ""}}).OrderBy("n.id desc").Limit(1).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var m Mirror
+	if err := d.SelectOne(&m, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// PendingMirrors returns the Mirrors not sent, or not updated, yet that are due before t.
+func PendingMirrors(d DB, t time.Time, limit uint64) ([]*Mirror, error) {
+	query, args, err := psql.Select("*").From(Mirror{}.name()).
+		Where(sq.And{sq.Or{sq.Eq{"event_id": ""}, sq.NotEq{"update_id": ""}}, sq.LtOrEq{"next_attempt": t}}).
+		OrderBy("next_attempt").Limit(limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	list, err := d.Select(Mirror{}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	m := make([]*Mirror, len(list))
+	for i := range list {
+		m[i] = list[i].(*Mirror)
+	}
+	return m, nil
+}
+
+// ListInvites returns the pending Invites of an Org.
+func ListInvites(d DB, roomID string, t time.Time) ([]*Invite, error) {
+	query, args, err := psql.Select("*").From(Invite{}.name()).Where(sq.And{
+		sq.Eq{"room_id": roomID, "used_at": nil, "revoked_at": nil},
+		sq.Gt{"expires_at": t},
+	}).OrderBy("created_at").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	list, err := d.Select(Invite{}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	i := make([]*Invite, len(list))
+	for j := range list {
+		i[j] = list[j].(*Invite)
+	}
+	return i, nil
+}
+
+// RevokeInvite revokes a pending Invite, returns sql.ErrNoRows if there's none.
+func RevokeInvite(d DB, roomID, id string, t time.Time) error {
+	return updateInvite(d, id, map[string]interface{}{"revoked_at": t}, sq.Eq{"room_id": roomID})
+}
+
+// updateInvite updates a pending Invite, returns sql.ErrNoRows if there's none.
+func updateInvite(d DB, id string, values map[string]interface{}, filter sq.Sqlizer) error {
+	return checkAffected(d, psql.Update(Invite{}.name()).SetMap(values).Where(sq.And{
+		sq.Eq{"id": id, "used_at": nil, "revoked_at": nil}, filter,
+	}))
+}
+
+// GetInvite returns the Invite with the token hash, nil if not found.
+func GetInvite(d DB, token string) (*Invite, error) {
+	query, args, err := psql.Select("*").From(Invite{}.name()).Where(sq.Eq{"token": token}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var i Invite
+	if err := d.SelectOne(&i, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &i, nil
+}
+
+// UseInvite marks a pending Invite as used by the user, returns sql.ErrNoRows if it's not pending.
+func UseInvite(d DB, id, userID string, t time.Time) error {
+	return updateInvite(d, id, map[string]interface{}{"used_at": t, "used_by": userID}, sq.Gt{"expires_at": t})
+}
+
+// SetInviteUser records the user of a claimed Invite.
+func SetInviteUser(d DB, id, userID string) error {
+	return checkAffected(d, psql.Update(Invite{}.name()).Set("used_by", userID).
+		Where(sq.And{sq.Eq{"id": id}, sq.NotEq{"used_at": nil}}))
+}
+
+// ReleaseInvite marks a used Invite as pending again.
+func ReleaseInvite(d DB, id string) error {
+	query, args, err := psql.Update(Invite{}.name()).SetMap(map[string]interface{}{"used_at": nil, "used_by": ""}).
+		Where(sq.Eq{"id": id}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(query, args...)
+	return err
+}
+
+// UpdateOrg updates an Org, returns sql.ErrNoRows if it doesn't exist.
+func UpdateOrg(d DB, org *Org) error {
+	n, err := d.Update(org)
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
 	}
 	return err
 }
+
+// DeleteOrg deletes an Org and its Invites. Its Notifications are archived, or deleted if purge is true.
+func DeleteOrg(d DB, roomID string, purge bool, t time.Time) error {
+	var queries []sq.Sqlizer
+	ids := `notification_id in (select id from ` + Notification{}.name() + ` where room_id = ?)`
+	queries = append(queries,
+		psql.Delete(Mirror{}.name()).Where(sq.Expr(ids, roomID)),
+		psql.Delete(Invite{}.name()).Where(sq.Eq{"room_id": roomID}),
+	)
+	if purge {
+		queries = append(queries,
+			psql.Delete(NotificationRead{}.name()).Where(sq.Expr(ids, roomID)),
+			psql.Delete(NotificationEdit{}.name()).Where(sq.Expr(ids, roomID)),
+			psql.Delete(NotificationRecipient{}.name()).Where(sq.Expr(ids, roomID)),
+			psql.Delete(PanicEvent{}.name()).Where(sq.Expr(ids, roomID)),
+			psql.Delete(Notification{}.name()).Where(sq.Eq{"room_id": roomID}),
+		)
+	} else {
+		queries = append(queries, psql.Update(Notification{}.name()).Set("archived_at", t).
+			Where(sq.Eq{"room_id": roomID, "archived_at": nil}))
+	}
+	queries = append(queries, psql.Delete(Org{}.name()).Where(sq.Eq{"room_id": roomID}))
+	for _, q := range queries {
+		query, args, err := q.ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := d.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -53,71 +53,83 @@ func TestInit(t *testing.T) {
 	}
 }
 
-func user(s string) *User {
-	return &User{Name: "user" + s}
-}
 func org(s string) *Org {
-	return &Org{ID: "org" + s, Name: "org" + s, Package: "com.org" + s}
-}
-func ou(o, u string, l int) *OrgUser {
-	return &OrgUser{UserID: "user" + u, OrgID: "org" + o, Level: l, Hash: o + "-" + u}
+	return &Org{RoomID: "!room" + s, Name: "org" + s, Package: "com.org" + s}
 }
-func not(id, o, u, t string, created int64) *Notification {
-	return &Notification{ID: id, UserID: "user" + u, Destination: "org" + o, Type: t, CreatedAt: created}
+func not(id, o, u, t string, created time.Time) *Notification {
+	return &Notification{ID: id, UserID: "user" + u, RoomID: "!room" + o, Type: t, CreatedAt: created}
 }
 
-func nu(n, u string, read int64) *NotificationUser {
-	return &NotificationUser{NotificationID: n, UserID: "user" + u, ReadAt: read}
-}
+var rules = map[string]int{"a": 0, "b": 0, "c": 50, "d": 100}
 
 func TestQueries(t *testing.T) {
-	now := time.Now().Unix()
+	now := time.Now()
 	records := []interface{}{
-		user("1"), user("2"), user("3"), org("1"), org("2"), org("3"),
-		ou("1", "1", 1), ou("2", "1", 0),
-		ou("2", "2", 1), ou("3", "2", 0),
-		ou("3", "3", 1), ou("1", "3", 0),
+		org("1"), org("2"), org("3"),
 		not("0001", "1", "1", "a", now),
 		not("0002", "1", "1", "b", now),
 		not("0003", "1", "1", "c", now),
 		not("0004", "1", "1", "d", now),
-		not("0005", "1", "1", "e", now),
-		not("0006", "1", "1", "f", now),
 		not("0011", "3", "3", "a", now),
 		not("0012", "3", "3", "b", now),
 		not("0013", "3", "3", "c", now),
 		not("0014", "3", "3", "d", now),
-		not("0015", "3", "3", "e", now),
-		not("0016", "3", "3", "f", now),
-	}
-	var nu = [][2]string{
-		{"0001", "user1"}, {"0002", "user1"}, {"0003", "user1"},
-		{"0002", "user3"}, {"0003", "user3"}, {"0011", "user3"}, {"0012", "user3"},
 	}
 	for _, r := range records {
 		if err := Create(dbMap, r); err != nil {
 			log.Fatal(r, err)
 		}
 	}
-	for _, n := range nu {
-		if err := MarkAsRead(dbMap, n[1], n[0], now, "a", "b", "c", "d"); err != nil {
-			log.Fatal(n, err)
+	levels := map[string]map[string]int{
+		"user1": {"!room1": 100, "!room2": 0},
+		"user2": {"!room2": 100, "!room3": 0},
+		"user3": {"!room3": 50, "!room1": 0},
+	}
+	var reads = [][2]string{
+		{"0001", "user1"}, {"0002", "user1"}, {"0003", "user1"},
+		{"0002", "user3"}, {"0011", "user3"}, {"0012", "user3"},
+	}
+	for _, r := range reads {
+		if err := MarkNotificationRead(dbMap, r[0], r[1], now); err != nil {
+			log.Fatal(r, err)
 		}
 	}
-
-	if err := MarkAsRead(dbMap, "user1", "0001", now, "a", "b", "c", "d"); err != sql.ErrNoRows {
-		log.Fatalf("Expected %#v, got %#v", sql.ErrNoRows, err)
+	since := now.Add(-time.Second)
+	testNotificationCount(t, since, levels, map[string][2]int{"user1": {4, 3}, "user2": {2, 0}, "user3": {5, 3}})
+	if err := MarkNotificationUnread(dbMap, "0002", "user3"); err != nil {
+		log.Fatal(err)
 	}
-	testNotificationCount(t, now, map[string][2]int{"user1": [2]int{6, 3}, "user2": [2]int{4, 0}, "user3": [2]int{10, 4}})
-	if err := MarkAsRead(dbMap, "user1", "", now, "a", "b", "c", "d"); err != nil {
+	if err := MarkAsRead(dbMap, "user1", now, levels["user1"], rules); err != nil {
 		log.Fatal(err)
 	}
-	testNotificationCount(t, now, map[string][2]int{"user1": [2]int{6, 6}, "user2": [2]int{4, 0}, "user3": [2]int{10, 4}})
+	testNotificationCount(t, since, levels, map[string][2]int{"user1": {4, 4}, "user2": {2, 0}, "user3": {5, 2}})
+	testPages(t, since, levels["user3"], Page{Limit: 2}, "0001", "0002", "0011", "0012", "0013")
+	testPages(t, since, levels["user3"], Page{Limit: 3, Backward: true}, "0013", "0012", "0011", "0002", "0001")
+}
+
+func testPages(t *testing.T, since time.Time, levels map[string]int, p Page, ids ...string) {
+	var got []string
+	for {
+		list, err := ListNotifications(dbMap, NotificationFilter{UserID: "user3", Since: since, Levels: levels, Rules: rules}, p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, n := range list {
+			got = append(got, n.ID)
+		}
+		if uint64(len(list)) < p.Limit {
+			break
+		}
+		p.From = list[len(list)-1].ID
+	}
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		log.Fatalf("expected %v, got %v", ids, got)
+	}
 }
 
-func testNotificationCount(t *testing.T, now int64, count map[string][2]int) {
+func testNotificationCount(t *testing.T, since time.Time, levels map[string]map[string]int, count map[string][2]int) {
 	for user, count := range count {
-		list, err := ListNotifications(dbMap, user, now, "a", "b", "c", "d")
+		list, err := ListNotifications(dbMap, NotificationFilter{UserID: user, Since: since, Levels: levels[user], Rules: rules}, Page{})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -129,7 +141,7 @@ func testNotificationCount(t *testing.T, now int64, count map[string][2]int) {
 		}
 		read := 0
 		for _, n := range list {
-			if n.ReadAt > 0 {
+			if n.Read {
 				read++
 			}
 		}
@@ -141,3 +153,143 @@ func testNotificationCount(t *testing.T, now int64, count map[string][2]int) {
 		}
 	}
 }
+
+func TestInvites(t *testing.T) {
+	now := time.Now()
+	for _, i := range []*Invite{
+		{ID: "i1", Token: "t1", RoomID: "!room1", Email: "a@example.com", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "i2", Token: "t2", RoomID: "!room1", Email: "b@example.com", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "i3", Token: "t3", RoomID: "!room1", Email: "c@example.com", CreatedAt: now, ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := Create(dbMap, i); err != nil {
+			log.Fatal(i, err)
+		}
+	}
+	if err := RevokeInvite(dbMap, "!room2", "i1", now); err != sql.ErrNoRows {
+		log.Fatalf("expected no rows, got %v", err)
+	}
+	if err := RevokeInvite(dbMap, "!room1", "i1", now); err != nil {
+		log.Fatal(err)
+	}
+	if err := RevokeInvite(dbMap, "!room1", "i1", now); err != sql.ErrNoRows {
+		log.Fatalf("expected no rows, got %v", err)
+	}
+	list, err := ListInvites(dbMap, "!room1", now)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "i2" {
+		log.Fatalf("expected i2, got %v", list)
+	}
+	if err := UseInvite(dbMap, "i3", "user1", now); err != sql.ErrNoRows {
+		log.Fatalf("expected no rows for expired invite, got %v", err)
+	}
+	if err := SetInviteUser(dbMap, "i2", "user1"); err != sql.ErrNoRows {
+		log.Fatalf("expected no rows for pending invite, got %v", err)
+	}
+	if err := UseInvite(dbMap, "i2", "", now); err != nil {
+		log.Fatal(err)
+	}
+	if err := SetInviteUser(dbMap, "i2", "user1"); err != nil {
+		log.Fatal(err)
+	}
+	if err := UseInvite(dbMap, "i2", "user2", now); err != sql.ErrNoRows {
+		log.Fatalf("expected no rows for used invite, got %v", err)
+	}
+	if i, err := GetInvite(dbMap, "t2"); err != nil || i == nil || i.UsedBy != "user1" {
+		log.Fatalf("unexpected invite %v, %v", i, err)
+	}
+	if err := ReleaseInvite(dbMap, "i2"); err != nil {
+		log.Fatal(err)
+	}
+	if list, err := ListInvites(dbMap, "!room1", now); err != nil || len(list) != 1 {
+		log.Fatalf("expected released invite, got %v, %v", list, err)
+	}
+}
+
+func TestVotes(t *testing.T) {
+	now := time.Now()
+	poll := not("0021", "4", "1", "poll", now)
+	poll.Content = &Content{Choices: []Choice{{Label: "A", Value: "a"}, {Label: "B", Value: "b"}}}
+	vote := func(id, u string, values ...string) *Notification {
+		n := not(id, "4", u, "vote", now)
+		n.RefID, n.Content = poll.ID, &Content{RefID: poll.ID, Values: values}
+		return n
+	}
+	for _, r := range []interface{}{poll, vote("0022", "1", "a"), vote("0023", "2", "a", "b")} {
+		if err := Create(dbMap, r); err != nil {
+			log.Fatal(r, err)
+		}
+	}
+	if err := Create(dbMap, vote("0024", "1", "b")); !IsDuplicate(err) {
+		log.Fatalf("expected duplicate vote, got %v", err)
+	}
+	if v, err := GetVote(dbMap, poll.ID, "user2"); err != nil || v == nil || v.ID != "0023" {
+		log.Fatalf("unexpected vote %v, %v", v, err)
+	}
+	if v, err := GetVote(dbMap, poll.ID, "user3"); err != nil || v != nil {
+		log.Fatalf("expected no vote, got %v, %v", v, err)
+	}
+	r, err := Tally(dbMap, poll)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if r.Voters != 2 || len(r.Results) != 2 || r.Results[0].Count != 2 || r.Results[1].Count != 1 {
+		log.Fatalf("unexpected results %+v", r)
+	}
+}
+
+func TestAnswers(t *testing.T) {
+	now := time.Now()
+	answer := func(id, u string) *Notification {
+		n := not(id, "5", u, "answer", now)
+		n.RefID = "0031"
+		return n
+	}
+	for _, r := range []interface{}{not("0031", "5", "1", "question", now), answer("0032", "2"), answer("0033", "3")} {
+		if err := Create(dbMap, r); err != nil {
+			log.Fatal(r, err)
+		}
+	}
+	rules := map[string]int{"question": 0, "answer": 50}
+	for user, expected := range map[string]string{
+		"user1": "[0031 0032 0033]", "user2": "[0031 0032]", "user3": "[0031 0033]", "user4": "[0031 0032 0033]",
+	} {
+		levels := map[string]int{"!room5": 0}
+		if user == "user4" {
+			levels["!room5"] = 50
+		}
+		list, err := ListNotifications(dbMap, NotificationFilter{UserID: user, Since: now.Add(-time.Second), Levels: levels, Rules: rules}, Page{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		var got []string
+		for _, n := range list {
+			got = append(got, n.ID)
+		}
+		if fmt.Sprint(got) != expected {
+			log.Fatalf("%s: expected %s, got %v", user, expected, got)
+		}
+	}
+}
+
+func TestDuePanics(t *testing.T) {
+	now := time.Now()
+	o := org("6")
+	o.Escalation = []int64{60}
+	expired := not("0042", "6", "1", "panic", now.Add(-2*time.Minute))
+	expired.ExpiresAt = &now
+	for _, r := range []interface{}{o, not("0041", "6", "1", "panic", now.Add(-2*time.Minute)), expired,
+		not("0043", "6", "1", "panic", now)} {
+		if err := Create(dbMap, r); err != nil {
+			log.Fatal(r, err)
+		}
+	}
+	list, err := DuePanics(dbMap, now, 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "0041" {
+		log.Fatalf("expected 0041, got %v", list)
+	}
+}
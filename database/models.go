@@ -3,7 +3,11 @@ package database
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type table interface {
@@ -17,6 +21,10 @@ type Org struct {
 	Name    string `db:"name" json:"name"`
 	Package string `db:"package" json:"package"`
 	Intent  string `db:"intent" json:"intent"`
+	// Retention is the lifetime of the Notifications in seconds, 0 uses the server default.
+	Retention int `db:"retention" json:"retention,omitempty"`
+	// Escalation is the delay in seconds, since it was sent, of each escalation of an unacknowledged Panic.
+	Escalation pq.Int64Array `db:"escalation" json:"escalation,omitempty"`
 }
 
 func (Org) name() string { return "organisations" }
@@ -30,11 +38,61 @@ type Notification struct {
 	ID        string    `db:"id,primarykey" json:"id"`
 	RoomID    string    `db:"room_id" json:"room_id"`
 	UserID    string    `db:"user_id" json:"user_id"`
-	Priority  int       `db:"priority" json:"priority"`
+	Priority  Priority  `db:"priority" json:"priority"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	Type      string    `db:"type" json:"type"`
 	Content   *Content  `db:"content" json:"content"`
 	Read      bool      `db:"-" json:"read,omitempty"`
+
+	CollapseKey string `db:"collapse_key" json:"-"`
+	RefID       string `db:"ref_id" json:"-"`
+	Superseded  bool   `db:"-" json:"superseded,omitempty"`
+
+	SendAt     *time.Time `db:"send_at" json:"send_at,omitempty"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	ArchivedAt *time.Time `db:"archived_at" json:"-"`
+	EditedAt   *time.Time `db:"edited_at" json:"edited_at,omitempty"`
+	Retracted  bool       `db:"retracted" json:"retracted,omitempty"`
+
+	// Recipients and the level range restrict who can see the Notification, besides its author.
+	Recipients []string `db:"-" json:"recipients,omitempty"`
+	MinLevel   *int     `db:"min_level" json:"min_level,omitempty"`
+	MaxLevel   *int     `db:"max_level" json:"max_level,omitempty"`
+
+	// ClosesAt comes from the options of a Poll, Anonymous hides the author of a Vote.
+	ClosesAt  *time.Time `db:"closes_at" json:"-"`
+	ClosedAt  *time.Time `db:"closed_at" json:"closed_at,omitempty"`
+	Anonymous bool       `db:"anonymous" json:"-"`
+
+	// Acknowledgement and escalation of a Panic.
+	AckedAt    *time.Time `db:"acked_at" json:"acked_at,omitempty"`
+	AckedBy    string     `db:"acked_by" json:"acked_by,omitempty"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolved_at,omitempty"`
+	Escalation int        `db:"escalation" json:"escalation,omitempty"`
+}
+
+// Targeted checks if the Notification is restricted to some members of the Org.
+func (n *Notification) Targeted() bool {
+	return len(n.Recipients) != 0 || n.MinLevel != nil || n.MaxLevel != nil
+}
+
+// Receives checks if a User with the level is a recipient of the Notification.
+func (n *Notification) Receives(userID string, level int) bool {
+	if n.UserID == userID {
+		return true
+	}
+	if n.MinLevel != nil && level < *n.MinLevel || n.MaxLevel != nil && level > *n.MaxLevel {
+		return false
+	}
+	if len(n.Recipients) == 0 {
+		return true
+	}
+	for _, r := range n.Recipients {
+		if r == userID {
+			return true
+		}
+	}
+	return false
 }
 
 func (Notification) name() string { return "notifications" }
@@ -43,12 +101,117 @@ func (Notification) unique() [][]string {
 	return [][]string{{"id"}}
 }
 
+// Priority is the urgency of a Notification, in JSON it's a number, a name is accepted too.
+// Critical Notifications are meant to bypass mutes and quiet hours.
+type Priority int
+
+// List of Priorities
+const (
+	PriorityLow      Priority = -1
+	PriorityNormal   Priority = 0
+	PriorityHigh     Priority = 1
+	PriorityCritical Priority = 2
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+// ParsePriority returns the Priority from its name or number.
+func ParsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unknown priority %q", s)
+	}
+	return Priority(v), nil
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// UnmarshalJSON decodes a Priority name or number.
+func (p *Priority) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var v int
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*p = Priority(v)
+		return nil
+	}
+	v, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
 // Content is the Notification main content.
 type Content struct {
-	Text        string   `json:"text"`
-	CollapseKey string   `json:"collapse_key,omitempty"`
-	RefID       string   `json:"ref_id,omitempty"`
-	Choices     []Choice `json:"choices,omitempty"`
+	Text        string           `json:"text"`
+	CollapseKey string           `json:"collapse_key,omitempty"`
+	RefID       string           `json:"ref_id,omitempty"`
+	Choices     []Choice         `json:"choices,omitempty"`
+	Selected    string           `json:"value,omitempty"`  // Choice of an Answer, or of a Vote as a single value
+	Values      []string         `json:"values,omitempty"` // Choices of a Vote
+	Poll        *PollOptions     `json:"poll,omitempty"`
+	Question    *QuestionOptions `json:"question,omitempty"`
+	Results     *PollResults     `json:"results,omitempty"` // Tally of a closed Poll
+}
+
+// Who can see the results of a Poll, besides its author and moderators.
+const (
+	ResultsPublic  = "public"  // members that can see the Poll
+	ResultsVoters  = "voters"  // members that voted
+	ResultsPrivate = "private" // nobody else
+)
+
+// Kinds of Answers to a Question.
+const (
+	AnswersText    = "text"    // free text
+	AnswersChoices = "choices" // one of the Choices of the Question
+)
+
+// QuestionOptions are the settings of a Question.
+type QuestionOptions struct {
+	Answers string `json:"answers,omitempty"`
+}
+
+// PollOptions are the settings of a Poll.
+// A Vote has between MinChoices and MaxChoices values, one if not set.
+type PollOptions struct {
+	Results    string     `json:"results,omitempty"`
+	ClosesAt   *time.Time `json:"closes_at,omitempty"`
+	Anonymous  bool       `json:"anonymous,omitempty"` // voters hidden from everyone
+	MinChoices int        `json:"min_choices,omitempty"`
+	MaxChoices int        `json:"max_choices,omitempty"`
+}
+
+// PollResults is the tally of a Poll.
+type PollResults struct {
+	PollID  string        `json:"poll_id"`
+	Voters  int64         `json:"voters"`
+	Results []ChoiceCount `json:"results"`
+}
+
+// ChoiceCount is the number of Votes of a Choice.
+type ChoiceCount struct {
+	Choice
+	Count int `json:"count"`
 }
 
 // Choice is an option for an Answer or a Pool
@@ -74,14 +237,106 @@ func (c *Content) Scan(value interface{}) error {
 	return json.Unmarshal([]byte(value.(string)), c)
 }
 
-// NotificationUser marks read Notification for a User
-type NotificationUser struct {
-	UserID   string    `db:"user_id,primarykey"`
-	LastRead time.Time `db:"last_read"`
+// NotificationRead marks a Notification as read for a User.
+type NotificationRead struct {
+	NotificationID string    `db:"notification_id,primarykey"`
+	UserID         string    `db:"user_id,primarykey"`
+	ReadAt         time.Time `db:"read_at"`
+}
+
+func (NotificationRead) name() string { return "notification_reads" }
+
+func (NotificationRead) unique() [][]string {
+	return [][]string{{"notification_id", "user_id"}}
+}
+
+// NotificationRecipient is a User that can see a targeted Notification.
+type NotificationRecipient struct {
+	NotificationID string `db:"notification_id,primarykey"`
+	UserID         string `db:"user_id,primarykey"`
+}
+
+func (NotificationRecipient) name() string { return "notification_recipients" }
+
+func (NotificationRecipient) unique() [][]string {
+	return [][]string{{"notification_id", "user_id"}}
+}
+
+// NotificationEdit is a previous version of an edited or retracted Notification.
+type NotificationEdit struct {
+	ID             string    `db:"id,primarykey" json:"id"`
+	NotificationID string    `db:"notification_id" json:"notification_id"`
+	UserID         string    `db:"user_id" json:"user_id"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	Content        *Content  `db:"content" json:"content"`
+}
+
+func (NotificationEdit) name() string { return "notification_edits" }
+
+func (NotificationEdit) unique() [][]string {
+	return [][]string{{"id"}}
+}
+
+// Types of PanicEvent.
+const (
+	EventSent      = "sent"
+	EventDelivered = "delivered" // received by a member
+	EventEscalated = "escalated"
+	EventAcked     = "acked"
+	EventResolved  = "resolved"
+)
+
+// PanicEvent is a step of the timeline of a Panic.
+type PanicEvent struct {
+	ID             string    `db:"id,primarykey" json:"id"`
+	NotificationID string    `db:"notification_id" json:"notification_id"`
+	Type           string    `db:"type" json:"type"`
+	UserID         string    `db:"user_id" json:"user_id,omitempty"`
+	Level          *int      `db:"level" json:"level,omitempty"` // minimum level reached by an escalation
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+func (PanicEvent) name() string { return "panic_events" }
+
+func (PanicEvent) unique() [][]string {
+	return [][]string{{"id"}}
+}
+
+// Mirror is a Notification to send to its Org room as a Matrix event.
+// UpdateID is set when the sent event must be edited or redacted.
+// The Mirror of a purged Notification is kept until its event is redacted.
+type Mirror struct {
+	NotificationID string    `db:"notification_id,primarykey"`
+	RoomID         string    `db:"room_id"`
+	Attempts       int       `db:"attempts"`
+	NextAttempt    time.Time `db:"next_attempt"`
+	EventID        string    `db:"event_id"`
+	UpdateID       string    `db:"update_id"`
+}
+
+func (Mirror) name() string { return "notification_mirrors" }
+
+func (Mirror) unique() [][]string {
+	return [][]string{{"notification_id"}}
+}
+
+// Invite is an invitation to join an Org, sent by email.
+type Invite struct {
+	ID        string     `db:"id,primarykey" json:"id"`
+	Token     string     `db:"token" json:"-"` // SHA-256 of the token sent
+	RoomID    string     `db:"room_id" json:"room_id"`
+	Email     string     `db:"email" json:"email"`
+	Admin     bool       `db:"admin" json:"admin"`
+	CreatedBy string     `db:"created_by" json:"created_by"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at,omitempty"`
+	UsedBy    string     `db:"used_by" json:"used_by,omitempty"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
 }
 
-func (NotificationUser) name() string { return "notification_users" }
+func (Invite) name() string { return "invites" }
 
-func (NotificationUser) unique() [][]string {
-	return [][]string{{"user_id"}}
+func (Invite) unique() [][]string {
+	return [][]string{{"id"}, {"token"}}
 }
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPriorityJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in, out  string
+		expected Priority
+	}{
+		{`"critical"`, `2`, PriorityCritical},
+		{`"low"`, `-1`, PriorityLow},
+		{`1`, `1`, PriorityHigh},
+		{`5`, `5`, Priority(5)},
+	} {
+		var p Priority
+		if err := json.Unmarshal([]byte(tc.in), &p); err != nil {
+			t.Fatal(tc.in, err)
+		}
+		if p != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.in, tc.expected, p)
+		}
+		if b, _ := json.Marshal(p); string(b) != tc.out {
+			t.Errorf("%s: expected %s, got %s", tc.in, tc.out, b)
+		}
+	}
+	var p Priority
+	if err := json.Unmarshal([]byte(`"urgent"`), &p); err == nil {
+		t.Error("expected error for unknown priority")
+	}
+}
+
+func TestReceives(t *testing.T) {
+	min, max := 50, 50
+	for _, tc := range []struct {
+		n        Notification
+		user     string
+		level    int
+		expected bool
+	}{
+		{Notification{}, "@a:x", 0, true},
+		{Notification{Recipients: []string{"@a:x"}}, "@a:x", 0, true},
+		{Notification{Recipients: []string{"@a:x"}}, "@b:x", 100, false},
+		{Notification{Recipients: []string{"@a:x"}, UserID: "@b:x"}, "@b:x", 0, true},
+		{Notification{MinLevel: &min}, "@a:x", 0, false},
+		{Notification{MinLevel: &min}, "@a:x", 100, true},
+		{Notification{MaxLevel: &max}, "@a:x", 100, false},
+		{Notification{MinLevel: &min, Recipients: []string{"@a:x"}}, "@a:x", 0, false},
+	} {
+		if v := tc.n.Receives(tc.user, tc.level); v != tc.expected {
+			t.Errorf("%+v %s %d: expected %v", tc.n, tc.user, tc.level, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package sender delivers messages to users outside of Matrix.
+package sender
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// Sender delivers a message to an email address.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP is a Sender that uses an SMTP server.
+type SMTP struct {
+	Address  string // host:port
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends an email.
+func (s SMTP) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Address, auth, s.From, []string{to}, s.message(to, subject, body))
+}
+
+// message returns the email, the subject is encoded to keep line breaks out of the headers.
+func (s SMTP) message(to, subject, body string) []byte {
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		s.From, to, mime.QEncoding.Encode("utf-8", subject), strings.Replace(body, "\n", "\r\n", -1)))
+}
+
+// Log is a Sender that writes messages to a logger, for local use.
+type Log struct {
+	*log.Logger
+}
+
+// Send logs the message.
+func (l Log) Send(to, subject, body string) error {
+	l.Printf("To: %s\nSubject: %s\n\n%s", to, subject, body)
+	return nil
+}
+
+// Message is a message kept by Memory.
+type Message struct {
+	To, Subject, Body string
+}
+
+// Memory is a Sender that keeps messages in memory, for tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send stores the message.
+func (m *Memory) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages returns the messages sent.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,22 @@
+package sender
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMTPMessage(t *testing.T) {
+	msg := string(SMTP{From: "bot@example.com"}.message("user@example.com", "Invitation to Org\r\nBcc: x@example.com", "a\nb"))
+	head := msg[:strings.Index(msg, "\r\n\r\n")]
+	for _, l := range strings.Split(head, "\r\n") {
+		if strings.HasPrefix(l, "Bcc:") {
+			t.Fatalf("header injected: %q", msg)
+		}
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\na\r\nb\r\n") {
+		t.Fatalf("unexpected body: %q", msg)
+	}
+	if msg := string(SMTP{}.message("user@example.com", "Invitation to Org", "")); !strings.Contains(msg, "Subject: Invitation to Org\r\n") {
+		t.Fatalf("unexpected subject: %q", msg)
+	}
+}
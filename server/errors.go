@@ -16,13 +16,43 @@ var (
 	ErrBadJSON              = ErrorResponse{http.StatusBadRequest, "BAD_JSON", "Please provide a valid JSON"}
 	ErrBadTimestamp         = ErrorResponse{http.StatusBadRequest, "BAD_TIMESTAMP", "Invalid RFC3339 timestamp"}
 	ErrBadEmail             = ErrorResponse{http.StatusBadRequest, "BAD_EMAIL", "Please provide a valid email"}
+	ErrBadUser              = ErrorResponse{http.StatusBadRequest, "BAD_USER", "Please provide a valid user ID"}
+	ErrBadLevel             = ErrorResponse{http.StatusBadRequest, "BAD_LEVEL", "Please provide a valid power level"}
+	ErrBadPage              = ErrorResponse{http.StatusBadRequest, "BAD_PAGE", "Invalid pagination parameters"}
+	ErrBadTimeout           = ErrorResponse{http.StatusBadRequest, "BAD_TIMEOUT", "Invalid timeout in milliseconds"}
+	ErrBadExpiry            = ErrorResponse{http.StatusBadRequest, "BAD_EXPIRY", "Expiry must be after creation and sending"}
+	ErrBadPriority          = ErrorResponse{http.StatusBadRequest, "BAD_PRIORITY", "Priority must be low, normal, high or critical"}
+	ErrBadRetention         = ErrorResponse{http.StatusBadRequest, "BAD_RETENTION", "Retention must be a positive number of seconds"}
+	ErrBadEscalation        = ErrorResponse{http.StatusBadRequest, "BAD_ESCALATION", "Escalation delays must be positive and increasing"}
+	ErrBadRecipient         = ErrorResponse{http.StatusBadRequest, "BAD_RECIPIENT", "Recipients must be members of the Org with a valid level range"}
+	ErrBadPoll              = ErrorResponse{http.StatusBadRequest, "BAD_POLL", "Poll needs two or more distinct choices and valid options"}
+	ErrBadChoice            = ErrorResponse{http.StatusBadRequest, "BAD_CHOICE", "Value must be one of the choices"}
+	ErrBadQuestion          = ErrorResponse{http.StatusBadRequest, "BAD_QUESTION", "Answers must be text, or choices with distinct values"}
+	ErrVoteConflict         = ErrorResponse{http.StatusConflict, "VOTE_CONFLICT", "Vote changed concurrently"}
+	ErrPollClosed           = ErrorResponse{http.StatusConflict, "POLL_CLOSED", "Poll is closed"}
+	ErrPanicAcked           = ErrorResponse{http.StatusConflict, "PANIC_ACKED", "Panic acknowledged already"}
+	ErrPanicResolved        = ErrorResponse{http.StatusConflict, "PANIC_RESOLVED", "Panic resolved already"}
+	ErrBadUpgrade           = ErrorResponse{http.StatusBadRequest, "BAD_UPGRADE", "WebSocket upgrade required"}
+	ErrBadOrigin            = ErrorResponse{http.StatusForbidden, "BAD_ORIGIN", "Origin not allowed"}
+	ErrUnknownMessage       = ErrorResponse{http.StatusBadRequest, "UNKNOWN_MESSAGE", "Unknown message type"}
 	ErrMissingReference     = ErrorResponse{http.StatusBadRequest, "BAD_REFERENCE", "Please specify a reference ID"}
 	ErrReferenceNotFound    = ErrorResponse{http.StatusNotFound, "UNKNOWN_REFERENCE", "Reference not found"}
+	ErrInviteNotFound       = ErrorResponse{http.StatusNotFound, "UNKNOWN_INVITE", "Invite not found"}
+	ErrInviteUsed           = ErrorResponse{http.StatusConflict, "INVITE_USED", "Invite already used"}
+	ErrInviteExpired        = ErrorResponse{http.StatusGone, "INVITE_EXPIRED", "Invite expired"}
+	ErrNoInviteSender       = ErrorResponse{http.StatusNotImplemented, "NOT_AVAILABLE", "Invites require an email sender"}
 	ErrNotificationNotFound = ErrorResponse{http.StatusNotFound, "UNKNOWN_NOTIFICATION", "Notification not found"}
+	ErrRetracted            = ErrorResponse{http.StatusGone, "RETRACTED_NOTIFICATION", "Notification retracted"}
 	ErrMissingToken         = ErrorResponse{http.StatusUnauthorized, "M_MISSING_TOKEN", "Missing access token"}
 	ErrUnknownToken         = ErrorResponse{http.StatusUnauthorized, "UNKNOWN_TOKEN", "Unknown Access Token"}
+	ErrBadAuthorization     = ErrorResponse{http.StatusUnauthorized, "M_MISSING_TOKEN", "Authorization header must be a Bearer token"}
+	ErrMissingHSToken       = ErrorResponse{http.StatusUnauthorized, "M_UNAUTHORIZED", "Missing homeserver token"}
+	ErrUnknownHSToken       = ErrorResponse{http.StatusForbidden, "M_FORBIDDEN", "Unknown homeserver token"}
+	ErrQueryToken           = ErrorResponse{http.StatusUnauthorized, "M_MISSING_TOKEN", "Access token must be in the Authorization header"}
 	ErrUnauthorized         = ErrorResponse{http.StatusUnauthorized, "M_UNAUTHORIZED", "Not allowed"}
+	ErrUnknownType          = ErrorResponse{http.StatusBadRequest, "UNKNOWN_TYPE", "Unknown notification type"}
 	ErrUnknownOrg           = ErrorResponse{http.StatusBadRequest, "UNKNOWN_ORG", "Unknown Org"}
+	ErrNoAppService         = ErrorResponse{http.StatusNotImplemented, "NOT_AVAILABLE", "Application Service mode required"}
 	ErrOrgExists            = ErrorResponse{http.StatusConflict, "ORG_EXISTS", "Org name already exists"}
 )
 
@@ -38,7 +68,7 @@ type ErrorResponse struct {
 }
 
 func (e ErrorResponse) with(err error) ErrorResponse {
-	if e, ok := err.(gomatrix.RespError); ok {
+	if e, ok := respError(err); ok {
 		return ErrorResponse{
 			Status: http.StatusBadRequest,
 			Code:   e.ErrCode,
@@ -51,9 +81,30 @@ func (e ErrorResponse) with(err error) ErrorResponse {
 
 func (e ErrorResponse) Error() string { return fmt.Sprintf("%s (%s)", e.Code, e.Err) }
 
+// respError returns the Matrix error wrapped in a gomatrix error.
+func respError(err error) (gomatrix.RespError, bool) {
+	switch e := err.(type) {
+	case gomatrix.RespError:
+		return e, true
+	case gomatrix.HTTPError:
+		r, ok := e.WrappedError.(gomatrix.RespError)
+		return r, ok
+	}
+	return gomatrix.RespError{}, false
+}
+
+// isUnknownToken checks if the homeserver rejected the access token.
+func isUnknownToken(err error) bool {
+	e, ok := respError(err)
+	return ok && e.ErrCode == "M_UNKNOWN_TOKEN"
+}
+
 func handler(fn func(c *gin.Context) error) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		if err := fn(c); err != nil {
+			if isUnknownToken(err) {
+				c.Set(keyUnknownToken, true)
+			}
 			e, ok := err.(ErrorResponse)
 			if !ok {
 				for i := range []int{0, 1, 2, 3} {
@@ -0,0 +1,67 @@
+package server
+
+import "sync"
+
+// hub wakes up the listeners of a room when a Notification is created in it.
+type hub struct {
+	mu        sync.Mutex
+	listeners map[*listener]struct{}
+}
+
+// listener is woken up by a new Notification in one of its rooms.
+// Wakes are coalesced, the listener must query the new Notifications.
+type listener struct {
+	rooms map[string]struct{}
+	wake  chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{listeners: make(map[*listener]struct{})}
+}
+
+// listen adds a listener for the rooms.
+func (h *hub) listen(rooms []string) *listener {
+	l := &listener{wake: make(chan struct{}, 1)}
+	h.mu.Lock()
+	l.rooms = roomSet(rooms)
+	h.listeners[l] = struct{}{}
+	h.mu.Unlock()
+	return l
+}
+
+// setRooms replaces the rooms of a listener.
+func (h *hub) setRooms(l *listener, rooms []string) {
+	h.mu.Lock()
+	l.rooms = roomSet(rooms)
+	h.mu.Unlock()
+}
+
+// close removes a listener.
+func (h *hub) close(l *listener) {
+	h.mu.Lock()
+	delete(h.listeners, l)
+	h.mu.Unlock()
+}
+
+// publish wakes up the listeners of a room without blocking.
+func (h *hub) publish(roomID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for l := range h.listeners {
+		if _, ok := l.rooms[roomID]; !ok {
+			continue
+		}
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func roomSet(rooms []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(rooms))
+	for _, r := range rooms {
+		m[r] = struct{}{}
+	}
+	return m
+}
@@ -0,0 +1,31 @@
+package server
+
+import "testing"
+
+func woken(l *listener) bool {
+	select {
+	case <-l.wake:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := newHub()
+	a, b := h.listen([]string{"!a", "!c"}), h.listen([]string{"!b"})
+	h.publish("!a")
+	h.publish("!c")
+	if !woken(a) || woken(a) {
+		t.Error("expected a single wake for a")
+	}
+	if woken(b) {
+		t.Error("unexpected wake for b")
+	}
+	h.setRooms(b, []string{"!a"})
+	h.close(a)
+	h.publish("!a")
+	if woken(a) || !woken(b) {
+		t.Error("expected a wake for b only")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLevelTTL is the default duration of power levels and joined rooms in cache.
+const DefaultLevelTTL = time.Minute
+
+// powerLevels is the content of a m.room.power_levels event.
+type powerLevels struct {
+	Users        map[string]int `json:"users"`
+	UsersDefault int            `json:"users_default"`
+}
+
+// level returns the power level of the user.
+func (p *powerLevels) level(userID string) int {
+	if lvl, ok := p.Users[userID]; ok {
+		return lvl
+	}
+	return p.UsersDefault
+}
+
+// max returns the highest power level of the room.
+func (p *powerLevels) max() int {
+	max := p.UsersDefault
+	for _, lvl := range p.Users {
+		if lvl > max {
+			max = lvl
+		}
+	}
+	return max
+}
+
+type roomEntry struct {
+	levels  *powerLevels
+	expires time.Time
+}
+
+type userEntry struct {
+	rooms   []string
+	expires time.Time
+}
+
+// levelCache keeps power levels per room and joined rooms per user.
+type levelCache struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	rooms map[string]roomEntry
+	users map[string]userEntry
+}
+
+func newLevelCache(ttl time.Duration) *levelCache {
+	if ttl <= 0 {
+		ttl = DefaultLevelTTL
+	}
+	return &levelCache{
+		ttl:   ttl,
+		rooms: make(map[string]roomEntry),
+		users: make(map[string]userEntry),
+	}
+}
+
+// levels returns the power levels of a room, using fetch if missing or expired.
+func (l *levelCache) levels(roomID string, fetch func() (*powerLevels, error)) (*powerLevels, error) {
+	l.mu.RLock()
+	e, ok := l.rooms[roomID]
+	l.mu.RUnlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.levels, nil
+	}
+	p, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.rooms[roomID] = roomEntry{levels: p, expires: time.Now().Add(l.ttl)}
+	l.mu.Unlock()
+	return p, nil
+}
+
+// joinedRooms returns the rooms of a user, using fetch if missing or expired.
+func (l *levelCache) joinedRooms(userID string, fetch func() ([]string, error)) ([]string, error) {
+	l.mu.RLock()
+	e, ok := l.users[userID]
+	l.mu.RUnlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.rooms, nil
+	}
+	rooms, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.users[userID] = userEntry{rooms: rooms, expires: time.Now().Add(l.ttl)}
+	l.mu.Unlock()
+	return rooms, nil
+}
+
+// invalidateRoom removes the power levels of a room.
+func (l *levelCache) invalidateRoom(roomID string) {
+	l.mu.Lock()
+	delete(l.rooms, roomID)
+	l.mu.Unlock()
+}
+
+// invalidateUser removes the joined rooms of a user.
+func (l *levelCache) invalidateUser(userID string) {
+	l.mu.Lock()
+	delete(l.users, userID)
+	l.mu.Unlock()
+}
+
+// sweep removes expired entries until the context is done.
+func (l *levelCache) sweep(ctx context.Context) {
+	t := time.NewTicker(l.ttl)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			l.mu.Lock()
+			for id, e := range l.rooms {
+				if now.After(e.expires) {
+					delete(l.rooms, id)
+				}
+			}
+			for id, e := range l.users {
+				if now.After(e.expires) {
+					delete(l.users, id)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
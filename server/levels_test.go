@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLevelCache(t *testing.T) {
+	l := newLevelCache(time.Hour)
+	calls := 0
+	fetch := func() (*powerLevels, error) {
+		calls++
+		return &powerLevels{Users: map[string]int{"@admin:server": LAdmin}, UsersDefault: LUser}, nil
+	}
+	for i := 0; i < 3; i++ {
+		p, err := l.levels("!room:server", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lvl := p.level("@admin:server"); lvl != LAdmin {
+			t.Fatalf("expected %d, got %d", LAdmin, lvl)
+		}
+		if lvl := p.level("@user:server"); lvl != LUser {
+			t.Fatalf("expected %d, got %d", LUser, lvl)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 fetch, got %d", calls)
+	}
+	l.invalidateRoom("!room:server")
+	if _, err := l.levels("!room:server", fetch); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 fetches, got %d", calls)
+	}
+}
+
+func TestLevelCacheError(t *testing.T) {
+	l := newLevelCache(time.Hour)
+	errFetch := errors.New("homeserver down")
+	if _, err := l.joinedRooms("@user:server", func() ([]string, error) { return nil, errFetch }); err != errFetch {
+		t.Fatalf("expected %v, got %v", errFetch, err)
+	}
+	rooms, err := l.joinedRooms("@user:server", func() ([]string, error) { return []string{"!room:server"}, nil })
+	if err != nil || len(rooms) != 1 {
+		t.Fatalf("unexpected result %v, %v", rooms, err)
+	}
+}
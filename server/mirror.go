@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// Default values for the Mirror.
+const (
+	DefaultMirrorEvent    = "org.secfirst.notification"
+	DefaultMirrorInterval = 30 * time.Second
+	maxMirrorBackoff      = time.Hour
+	mirrorBatch           = 100
+)
+
+// mirror sends the Notifications to their Org rooms, retrying on failure.
+type mirror struct {
+	eventType string
+	interval  time.Duration
+	wake      chan struct{}
+}
+
+// mirrorEvent is the content of the Matrix event sent for a Notification.
+// A Notification with a collapse key replaces the event of the previous one,
+// an edit replaces the event of the Notification with its new content.
+type mirrorEvent struct {
+	Body       string            `json:"body"`
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Priority   database.Priority `json:"priority"`
+	Content    *database.Content `json:"content,omitempty"`
+	RelatesTo  *relatesTo        `json:"m.relates_to,omitempty"`
+	NewContent *mirrorEvent      `json:"m.new_content,omitempty"`
+}
+
+// relatesTo is a relation between Matrix events.
+type relatesTo struct {
+	RelType string `json:"rel_type"`
+	EventID string `json:"event_id"`
+}
+
+// WithMirror sends every new Notification to its Org room as a Matrix event of the given type.
+// Requires Application Service mode, it is ignored otherwise.
+func WithMirror(eventType string, interval time.Duration) Option {
+	if eventType == "" {
+		eventType = DefaultMirrorEvent
+	}
+	if interval <= 0 {
+		interval = DefaultMirrorInterval
+	}
+	return func(s *Server) {
+		s.mirror = &mirror{eventType: eventType, interval: interval, wake: make(chan struct{}, 1)}
+	}
+}
+
+// notify wakes up the mirror loop without blocking.
+func (m *mirror) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runMirror sends pending Mirrors until the context is done.
+func (s *Server) runMirror(ctx context.Context) {
+	t := time.NewTicker(s.mirror.interval)
+	defer t.Stop()
+	for {
+		if err := s.sendMirrors(time.Now()); err != nil {
+			log.Println("Mirror error:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-s.mirror.wake:
+		}
+	}
+}
+
+// sendMirrors sends the Mirrors due before t, and reschedules the failed ones.
+func (s *Server) sendMirrors(t time.Time) error {
+	list, err := database.PendingMirrors(s.db, t, mirrorBatch)
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		v, err := database.Get(s.db, database.Notification{}, m.NotificationID)
+		if err != nil {
+			return err
+		}
+		n, _ := v.(*database.Notification)
+		if m.EventID == "" && (n == nil || n.Retracted) {
+			if _, err := s.db.Delete(m); err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case n == nil:
+			// the Notification was purged, the Mirror is deleted once its event is redacted
+			if err = s.redactMirror(m); err == nil {
+				if _, err := s.db.Delete(m); err != nil {
+					return err
+				}
+				continue
+			}
+		case m.EventID == "":
+			m.EventID, err = s.sendMirror(n)
+		case n.Retracted:
+			err = s.redactMirror(m)
+		default:
+			err = s.editMirror(n, m)
+		}
+		if err != nil {
+			log.Printf("Mirror %s failed (attempt %d): %s", m.NotificationID, m.Attempts+1, err)
+			m.Attempts++
+			m.NextAttempt = t.Add(mirrorBackoff(s.mirror.interval, m.Attempts))
+		} else {
+			m.Attempts, m.UpdateID = 0, ""
+		}
+		if err := database.Update(s.db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newMirrorEvent returns the event content of a Notification.
+func newMirrorEvent(n *database.Notification) mirrorEvent {
+	e := mirrorEvent{ID: n.ID, Type: n.Type, Priority: n.Priority, Content: n.Content}
+	if n.Content != nil {
+		e.Body = n.Content.Text
+	}
+	return e
+}
+
+// sendMirror sends the Notification event, using its ID as transaction ID to avoid duplicates.
+func (s *Server) sendMirror(n *database.Notification) (string, error) {
+	e := newMirrorEvent(n)
+	prev, err := database.LastMirror(s.db, n)
+	if err != nil {
+		return "", err
+	}
+	if prev != nil {
+		e.RelatesTo = &relatesTo{RelType: "m.replace", EventID: prev.EventID}
+	}
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	bot := s.as.bot
+	url := bot.BuildURL("rooms", n.RoomID, "send", s.mirror.eventType, n.ID)
+	if _, err := bot.MakeRequest("PUT", url, &e, &resp); err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// editMirror replaces the sent event with the edited Notification, using the update ID as transaction ID.
+func (s *Server) editMirror(n *database.Notification, m *database.Mirror) error {
+	content := newMirrorEvent(n)
+	e := content
+	e.NewContent = &content
+	e.RelatesTo = &relatesTo{RelType: "m.replace", EventID: m.EventID}
+	bot := s.as.bot
+	_, err := bot.MakeRequest("PUT", bot.BuildURL("rooms", n.RoomID, "send", s.mirror.eventType, m.UpdateID), &e, nil)
+	return err
+}
+
+// redactMirror redacts the sent event of a retracted or purged Notification, using the update ID as transaction ID.
+func (s *Server) redactMirror(m *database.Mirror) error {
+	bot := s.as.bot
+	_, err := bot.MakeRequest("PUT", bot.BuildURL("rooms", m.RoomID, "redact", m.EventID, m.UpdateID),
+		&struct {
+			Reason string `json:"reason"`
+		}{"Notification removed"}, nil)
+	return err
+}
+
+// mirrorBackoff doubles the interval for each attempt, up to maxMirrorBackoff.
+func mirrorBackoff(interval time.Duration, attempts int) time.Duration {
+	d := interval
+	for i := 1; i < attempts && d < maxMirrorBackoff; i++ {
+		d *= 2
+	}
+	if d > maxMirrorBackoff {
+		d = maxMirrorBackoff
+	}
+	return d
+}
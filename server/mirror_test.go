@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestMirrorBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{10, maxMirrorBackoff},
+	} {
+		if d := mirrorBackoff(time.Minute, tc.attempts); d != tc.expected {
+			t.Errorf("attempt %d: expected %s, got %s", tc.attempts, tc.expected, d)
+		}
+	}
+}
+
+func TestUpdateMirrorEvent(t *testing.T) {
+	var requests []string
+	var body map[string]interface{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"event_id":"$new"}`))
+	}))
+	defer hs.Close()
+	bot, err := gomatrix.NewClient(hs.URL, "@bot:hs", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{as: &appService{bot: bot}, mirror: &mirror{eventType: DefaultMirrorEvent}}
+	n := &database.Notification{ID: "1", RoomID: "!room", Type: NAnnouncement, Content: &database.Content{Text: "edited"}}
+	m := &database.Mirror{NotificationID: "1", RoomID: "!room", EventID: "$event", UpdateID: "2"}
+
+	if err := s.editMirror(n, m); err != nil {
+		t.Fatal(err)
+	}
+	if r := body["m.relates_to"].(map[string]interface{}); r["rel_type"] != "m.replace" || r["event_id"] != "$event" {
+		t.Errorf("unexpected relation %v", r)
+	}
+	if c := body["m.new_content"].(map[string]interface{}); c["body"] != "edited" {
+		t.Errorf("unexpected new content %v", c)
+	}
+	if err := s.redactMirror(m); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"PUT /_matrix/client/r0/rooms/!room/send/" + DefaultMirrorEvent + "/2",
+		"PUT /_matrix/client/r0/rooms/!room/redact/$event/2",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests %q", requests)
+	}
+}
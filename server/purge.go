@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// DefaultPurgeInterval is the default interval of the purge of expired Notifications.
+const DefaultPurgeInterval = time.Hour
+
+// retention is the default lifetime of Notifications, and how often the expired ones are deleted.
+type retention struct {
+	lifetime time.Duration
+	interval time.Duration
+}
+
+// WithRetention deletes the Notifications older than lifetime, unless their Org has its own retention.
+// A zero lifetime keeps them forever, the purge still deletes the expired ones every interval.
+func WithRetention(lifetime, interval time.Duration) Option {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return func(s *Server) { s.retention = retention{lifetime: lifetime, interval: interval} }
+}
+
+// runPurge deletes the expired Notifications until the context is done.
+func (s *Server) runPurge(ctx context.Context) {
+	t := time.NewTicker(s.retention.interval)
+	defer t.Stop()
+	for {
+		n, err := database.Purge(s.db, time.Now(), s.retention.lifetime)
+		if err != nil {
+			log.Println("Purge error:", err)
+		} else if n > 0 {
+			log.Printf("Purged %d notifications", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// Default values for the Scheduler.
+const (
+	DefaultScheduleInterval = 5 * time.Second
+	scheduleBatch           = 100
+)
+
+// WithScheduler sets how often the scheduled Notifications are checked.
+func WithScheduler(interval time.Duration) Option {
+	if interval <= 0 {
+		interval = DefaultScheduleInterval
+	}
+	return func(s *Server) { s.schedule = interval }
+}
+
+// runScheduler publishes the due Notifications, closes the due Polls and escalates the due Panics,
+// until the context is done.
+func (s *Server) runScheduler(ctx context.Context) {
+	t := time.NewTicker(s.schedule)
+	defer t.Stop()
+	for {
+		now := time.Now()
+		if err := s.publishDue(now); err != nil {
+			log.Println("Scheduler error:", err)
+		}
+		if err := s.closeDue(now); err != nil {
+			log.Println("Scheduler error:", err)
+		}
+		if err := s.escalateDue(now); err != nil {
+			log.Println("Scheduler error:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// publishDue publishes the Notifications scheduled before t.
+func (s *Server) publishDue(t time.Time) error {
+	for {
+		list, err := database.DueNotifications(s.db, t, scheduleBatch)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			if err := s.publishNotification(n); err != nil {
+				return err
+			}
+		}
+		if len(list) < scheduleBatch {
+			return nil
+		}
+	}
+}
+
+// publishNotification makes a scheduled Notification visible with a new ID, then delivers it.
+func (s *Server) publishNotification(n *database.Notification) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil {
+			s.delivered(n)
+		}
+	}()
+	if n.Recipients, err = database.Recipients(tx, n.ID); err != nil {
+		return err
+	}
+	if err = database.PublishNotification(tx, n, newULID(), time.Now()); err != nil {
+		return err
+	}
+	if n.Type == NPanic {
+		if err = addPanicEvent(tx, n, database.EventSent, n.UserID, n.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if s.mirror != nil && !n.Targeted() {
+		err = database.Create(tx, &database.Mirror{NotificationID: n.ID, RoomID: n.RoomID, NextAttempt: n.CreatedAt})
+	}
+	return err
+}
+
+// closeDue closes the Polls with a closing time before t.
+func (s *Server) closeDue(t time.Time) error {
+	for {
+		list, err := database.DuePolls(s.db, t, scheduleBatch)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			if err := s.closePoll(n, t); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if len(list) < scheduleBatch {
+			return nil
+		}
+	}
+}
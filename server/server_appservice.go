@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/gomatrix"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// maxTransactions is the number of transaction IDs kept to detect retries.
+const maxTransactions = 1000
+
+// appService holds the Application Service credentials.
+type appService struct {
+	hsToken string
+	bot     *gomatrix.Client
+
+	mu   sync.Mutex
+	txns map[string]struct{}
+	ids  []string
+}
+
+// seen checks if a transaction was already processed, and records it.
+func (a *appService) seen(txnID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.txns[txnID]; ok {
+		return true
+	}
+	a.txns[txnID] = struct{}{}
+	a.ids = append(a.ids, txnID)
+	if len(a.ids) > maxTransactions {
+		delete(a.txns, a.ids[0])
+		a.ids = a.ids[1:]
+	}
+	return false
+}
+
+// WithAppService runs the Server as an Application Service, using userID and asToken for privileged actions.
+func WithAppService(userID, asToken, hsToken string) Option {
+	return func(s *Server) {
+		bot, err := gomatrix.NewClient(s.matrix, userID, asToken)
+		if err != nil {
+			panic(err)
+		}
+		s.as = &appService{hsToken: hsToken, bot: bot, txns: make(map[string]struct{})}
+	}
+}
+
+// AuthenticateHomeserver checks the homeserver token of a request.
+func (s *Server) AuthenticateHomeserver() gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			token = c.Query("access_token")
+		}
+		if token == "" {
+			return ErrMissingHSToken
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.as.hsToken)) != 1 {
+			return ErrUnknownHSToken
+		}
+		return nil
+	})
+}
+
+// Transaction receives the events pushed by the homeserver.
+func (s *Server) Transaction(txnID string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		var req struct {
+			Events []gomatrix.Event `json:"events"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			return ErrBadJSON
+		}
+		if !s.as.seen(c.Param(txnID)) {
+			for i := range req.Events {
+				s.handleEvent(&req.Events[i])
+			}
+		}
+		c.JSON(http.StatusOK, struct{}{})
+		return nil
+	})
+}
+
+// handleEvent updates the caches according to the room state changes,
+// and creates Notifications from room messages.
+func (s *Server) handleEvent(e *gomatrix.Event) {
+	switch e.Type {
+	case "m.room.power_levels":
+		s.levels.invalidateRoom(e.RoomID)
+	case "m.room.member":
+		if e.StateKey != nil {
+			s.levels.invalidateUser(*e.StateKey)
+		}
+	default:
+		if e.Sender == s.as.bot.UserID {
+			return
+		}
+		n, err := parseEvent(e, s.notificationEvent())
+		if n == nil && err == nil {
+			return
+		}
+		if err == nil {
+			err = s.createEventNotification(e, n)
+		}
+		if err != nil {
+			log.Printf("Event %s: %s", e.ID, err)
+			msg := "Notification not created"
+			if r, ok := err.(ErrorResponse); ok {
+				msg += ": " + r.Err
+			}
+			s.as.bot.SendNotice(e.RoomID, msg)
+		}
+	}
+}
+
+// createEventNotification creates a Notification from an event, if the room is an Org.
+func (s *Server) createEventNotification(e *gomatrix.Event, n *database.Notification) error {
+	v, err := s.db.Get(database.Org{}, e.RoomID)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return ErrUnknownOrg
+	}
+	p, err := s.roomLevels(s.as.bot, e.RoomID)
+	if err != nil {
+		return err
+	}
+	n.RoomID, n.UserID = e.RoomID, e.Sender
+	return s.createNotification(n, p.level(e.Sender))
+}
+
+// notificationEvent returns the custom event type of Notifications.
+func (s *Server) notificationEvent() string {
+	if s.mirror != nil {
+		return s.mirror.eventType
+	}
+	return DefaultMirrorEvent
+}
+
+// commands creates a Notification from a room message, i.e. "!poll Question | Yes | No".
+var commands = map[string]string{
+	"!announce":  NAnnouncement,
+	"!broadcast": NBroadcast,
+	"!panic":     NPanic,
+	"!question":  NQuestion,
+	"!poll":      NPoll,
+}
+
+// parseEvent returns the Notification for a custom event or a command, nil if the event is not one.
+func parseEvent(e *gomatrix.Event, eventType string) (*database.Notification, error) {
+	switch e.Type {
+	case eventType:
+		b, err := json.Marshal(e.Content)
+		if err != nil {
+			return nil, err
+		}
+		var m mirrorEvent
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, ErrBadJSON
+		}
+		return &database.Notification{Type: m.Type, Priority: m.Priority, Content: m.Content}, nil
+	case "m.room.message":
+		body, _ := e.Body()
+		fields := strings.Fields(body)
+		if len(fields) == 0 {
+			return nil, nil
+		}
+		t, ok := commands[fields[0]]
+		if !ok {
+			return nil, nil
+		}
+		parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(body), fields[0]), "|")
+		content := database.Content{Text: strings.TrimSpace(parts[0])}
+		for _, p := range parts[1:] {
+			if p = strings.TrimSpace(p); p != "" {
+				content.Choices = append(content.Choices, database.Choice{Label: p, Value: p})
+			}
+		}
+		return &database.Notification{Type: t, Content: &content}, nil
+	}
+	return nil, nil
+}
+
+// botClient returns the Application Service client, or the user client if not available.
+func (s *Server) botClient(c *gin.Context) *gomatrix.Client {
+	if s.as != nil {
+		return s.as.bot
+	}
+	return getClient(c)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+func TestParseEvent(t *testing.T) {
+	msg := func(body string) *gomatrix.Event {
+		return &gomatrix.Event{Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": body}}
+	}
+	if n, err := parseEvent(msg("hello !poll"), DefaultMirrorEvent); n != nil || err != nil {
+		t.Fatalf("expected nothing, got %#v, %v", n, err)
+	}
+	n, err := parseEvent(msg("!poll Lunch? | Pizza | Sushi |"), DefaultMirrorEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Type != NPoll || n.Content.Text != "Lunch?" || len(n.Content.Choices) != 2 || n.Content.Choices[1].Value != "Sushi" {
+		t.Fatalf("unexpected poll %#v, %#v", n, n.Content)
+	}
+	n, err = parseEvent(&gomatrix.Event{Type: DefaultMirrorEvent, Content: map[string]interface{}{
+		"type":     NAnnouncement,
+		"priority": 1,
+		"content":  map[string]interface{}{"text": "Meeting at 5"},
+	}}, DefaultMirrorEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Type != NAnnouncement || n.Priority != 1 || n.Content.Text != "Meeting at 5" {
+		t.Fatalf("unexpected announcement %#v, %#v", n, n.Content)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/matrix-org/gomatrix"
@@ -26,9 +27,11 @@ const (
 	NBroadcast    = "broadcast"    // Broadcast, sent by admin, seen by admin
 	NAnnouncement = "announcement" // Announcement, sent by admin, seen by user
 	NQuestion     = "question"     // Question, sent by admin, seen by user
-	NAnswer       = "answer"       // Answer, sent by user, seen by user, requires Question
-	NPoll         = "poll  "       // Poll, sent by admin, seen by user
+	NAnswer       = "answer"       // Answer, sent by user, seen by mod and the Question author, requires Question
+	NPoll         = "poll"         // Poll, sent by admin, seen by user
 	NVote         = "vote"         // Vote, sent by user, seen by admin, requires Pool
+	NResults      = "results"      // Results, sent when a Poll closes, seen by user
+	NEscalation   = "escalation"   // Escalation, sent when a Panic is not acknowledged, seen by mod
 )
 
 // List of User Levels
@@ -43,9 +46,11 @@ var rulesView = map[string]int{
 	NBroadcast:    LMod,
 	NAnnouncement: LUser,
 	NQuestion:     LUser,
-	NAnswer:       LUser,
+	NAnswer:       LMod,
 	NPoll:         LUser,
 	NVote:         LMod,
+	NResults:      LUser,
+	NEscalation:   LMod,
 }
 
 var rulesCreate = map[string]int{
@@ -55,7 +60,14 @@ var rulesCreate = map[string]int{
 	NQuestion:     LMod,
 	NAnswer:       LUser,
 	NPoll:         LMod,
-	NVote:         LMod,
+	NVote:         LUser,
+}
+
+var rulesPriority = map[database.Priority]int{
+	database.PriorityLow:      LUser,
+	database.PriorityNormal:   LUser,
+	database.PriorityHigh:     LUser,
+	database.PriorityCritical: LMod,
 }
 
 // List of Levels
@@ -65,41 +77,149 @@ const (
 	LvlOwner
 )
 
+// Option is a Server configuration option.
+type Option func(*Server)
+
+// WithTokenCache sets the duration of valid and invalid tokens in cache and its maximum size.
+func WithTokenCache(ttl, invalidTTL time.Duration, size int) Option {
+	return func(s *Server) { s.tokens = newTokenCache(ttl, invalidTTL, size) }
+}
+
+// WithLevelCache sets the duration of power levels and joined rooms in cache.
+func WithLevelCache(ttl time.Duration) Option {
+	return func(s *Server) { s.levels = newLevelCache(ttl) }
+}
+
+// WithoutQueryToken rejects access tokens provided in the query string.
+func WithoutQueryToken() Option {
+	return func(s *Server) { s.noQueryToken = true }
+}
+
+// WithOrigins sets the origins, besides the server itself, allowed to open a WebSocket from a browser.
+func WithOrigins(origins ...string) Option {
+	return func(s *Server) { s.origins = origins }
+}
+
 // NewServer returns a new Server.
-func NewServer(address, matrix string, db *gorp.DbMap) *Server {
+func NewServer(address, matrix string, db *gorp.DbMap, opts ...Option) *Server {
 	engine := gin.Default()
 	s := Server{
-		server: &http.Server{Addr: address, Handler: engine},
-		db:     db,
-		matrix: matrix,
+		server:    &http.Server{Addr: address, Handler: engine},
+		db:        db,
+		matrix:    matrix,
+		tokens:    newTokenCache(0, 0, 0),
+		levels:    newLevelCache(0),
+		invites:   newInviter(nil, 0, ""),
+		hub:       newHub(),
+		schedule:  DefaultScheduleInterval,
+		retention: retention{interval: DefaultPurgeInterval},
 	}
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	for _, fn := range opts {
+		fn(&s)
+	}
+	if s.mirror != nil && s.as == nil {
+		log.Println("Mirror disabled: Application Service mode required")
+		s.mirror = nil
+	}
+	engine.POST("/_matrix/client/r0/organisation/:name/verify", s.ParseRequest(verifyRequest{}), s.VerifyInvite("name"))
 	auth := engine.Group("/_matrix/client/r0/", s.Authenticate())
 
 	org := auth.Group("/organisation/")
 	org.GET("", s.ListOrgs())
 	org.POST("", s.ParseRequest(database.Org{}), s.CreateOrg())
 	org.GET(":name", s.GetOrgByName("name"))
+	org.PATCH(":name", s.ParseRequest(orgUpdate{}), s.UpdateOrg("name"))
+	org.DELETE(":name", s.DeleteOrg("name"))
+	org.GET(":name/invite", s.ListInvites("name"))
+	org.POST(":name/invite", s.ParseRequest(database.Invite{}), s.CreateInvite("name"))
+	org.DELETE(":name/invite/:id", s.RevokeInvite("name", "id"))
+	org.GET(":name/members", s.ListMembers("name"))
+	org.POST(":name/members", s.ParseRequest(memberRequest{}), s.AddMember("name"))
+	org.DELETE(":name/members/:user", s.RemoveMember("name", "user"))
+	org.PUT(":name/members/:user/level", s.ParseRequest(levelRequest{}), s.SetMemberLevel("name", "user"))
 
 	not := auth.Group("/notification/")
 	not.GET("", s.ViewNotifications())
+	not.GET(":id", named("id", map[string]gin.HandlerFunc{
+		"stream": s.StreamNotifications(),
+		"ws":     s.NotificationSocket(),
+	}, s.GetNotification("id")))
+	not.GET(":id/results", s.PollResults("id"))
+	not.GET(":id/answers", s.QuestionAnswers("id"))
+	not.PATCH(":id/close", s.ClosePoll("id"))
+	not.PATCH(":id/ack", s.AckPanic("id"))
+	not.PATCH(":id/resolve", s.ResolvePanic("id"))
+	not.GET(":id/timeline", s.PanicTimeline("id"))
 	not.POST("", s.ParseRequest(database.Notification{}), s.CreateNotification())
 	not.PATCH("", s.ReadNotifications())
+	not.PATCH(":id", s.ParseRequest(editRequest{}), s.EditNotification("id"))
+	not.DELETE(":id", s.RetractNotification("id"))
+	not.PATCH(":id/read", s.ReadNotification("id"))
+	not.DELETE(":id/read", s.UnreadNotification("id"))
+
+	sch := auth.Group("/scheduled/")
+	sch.GET("", s.ListScheduled())
+	sch.PUT(":id", s.ParseRequest(scheduleRequest{}), s.Reschedule("id"))
+	sch.DELETE(":id", s.CancelScheduled("id"))
+
+	if s.as != nil {
+		as := engine.Group("/", s.AuthenticateHomeserver())
+		as.PUT("/transactions/:txnId", s.Transaction("txnId"))
+		as.PUT("/_matrix/app/v1/transactions/:txnId", s.Transaction("txnId"))
+	}
 
 	return &s
 }
 
 // Server is a gin handler generator.
 type Server struct {
-	server *http.Server
-	db     *gorp.DbMap
-	matrix string
+	server    *http.Server
+	db        *gorp.DbMap
+	matrix    string
+	tokens    *tokenCache
+	levels    *levelCache
+	as        *appService
+	mirror    *mirror
+	invites   *inviter
+	hub       *hub
+	schedule  time.Duration
+	retention retention
+	ctx       context.Context // background tasks
+	stop      context.CancelFunc
+
+	noQueryToken bool
+	origins      []string
+}
+
+// named calls the handler of a fixed value of the parameter, or fallback.
+// It allows fixed paths next to a parameter, that the router rejects.
+func named(param string, handlers map[string]gin.HandlerFunc, fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h, ok := handlers[c.Param(param)]; ok {
+			h(c)
+			return
+		}
+		fallback(c)
+	}
 }
 
-// Run starts the Server.
-func (s *Server) Run() error { return s.server.ListenAndServe() }
+// Run starts the Server and its background tasks.
+func (s *Server) Run() error {
+	go s.levels.sweep(s.ctx)
+	go s.runScheduler(s.ctx)
+	go s.runPurge(s.ctx)
+	if s.mirror != nil {
+		go s.runMirror(s.ctx)
+	}
+	return s.server.ListenAndServe()
+}
 
-// Shutdown closes the server
-func (s *Server) Shutdown(ctx context.Context) error { return s.server.Shutdown(ctx) }
+// Shutdown closes the server and stops its background tasks.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop()
+	return s.server.Shutdown(ctx)
+}
 
 // ParseRequest parses the request into a v element, that must be a pointer.
 func (s *Server) ParseRequest(v interface{}) gin.HandlerFunc {
@@ -119,42 +239,102 @@ func (s *Server) ParseRequest(v interface{}) gin.HandlerFunc {
 }
 
 const (
-	keyUser    = "user"
-	keyClient  = "client"
-	keyRequest = "request"
+	keyUser         = "user"
+	keyClient       = "client"
+	keyRequest      = "request"
+	keyUnknownToken = "unknown_token"
 )
 
-// Authenticate saves the user associated with the token provided in query.
+// Authenticate saves the user associated with the token provided in header or query.
+// If the homeserver rejects the token while handling the request, the token is evicted from cache.
 func (s *Server) Authenticate() gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
-		client, err := gomatrix.NewClient(s.matrix, "", c.Query("access_token"))
+		token, err := s.getToken(c)
 		if err != nil {
 			return err
 		}
-		var resp struct {
-			UserID string `json:"user_id"`
-		}
-		url := client.BuildURL("/account/whoami")
-		if _, err := client.MakeRequest("GET", url, nil, &resp); err != nil {
+		e, err := s.whoami(token)
+		if err != nil {
 			return err
 		}
-
-		c.Set(keyUser, resp.UserID)
-		c.Set(keyClient, client)
+		if !e.valid() {
+			return ErrUnknownToken
+		}
+		c.Set(keyUser, e.userID)
+		c.Set(keyClient, e.client)
+		c.Next()
+		if c.GetBool(keyUnknownToken) {
+			s.tokens.evict(token)
+		}
 		return nil
 	})
 }
 
-func getUser(c *gin.Context) string             { return c.MustGet(keyUser).(string) }
-func getClient(c *gin.Context) *gomatrix.Client { return c.MustGet(keyClient).(*gomatrix.Client) }
-func getRequest(c *gin.Context) interface{}     { return c.MustGet(keyRequest) }
+// getToken returns the access token, the Authorization header is preferred to the query.
+// The authentication scheme is case insensitive (RFC 7235).
+func (s *Server) getToken(c *gin.Context) (string, error) {
+	if h := c.GetHeader("Authorization"); h != "" {
+		scheme, token := h, ""
+		if i := strings.IndexByte(h, ' '); i >= 0 {
+			scheme, token = h[:i], strings.TrimSpace(h[i+1:])
+		}
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", ErrBadAuthorization
+		}
+		if token == "" {
+			return "", ErrMissingToken
+		}
+		return token, nil
+	}
+	token := c.Query("access_token")
+	if token == "" {
+		return "", ErrMissingToken
+	}
+	if s.noQueryToken {
+		return "", ErrQueryToken
+	}
+	return token, nil
+}
+
+// EvictToken removes an access token from cache, forcing a new validation.
+func (s *Server) EvictToken(token string) { s.tokens.evict(token) }
 
-func getRooms(c *gin.Context) ([]string, error) {
-	r, err := getClient(c).JoinedRooms()
+// whoami returns the cached token entry or asks the homeserver.
+func (s *Server) whoami(token string) (*tokenEntry, error) {
+	if e, ok := s.tokens.get(token); ok {
+		return e, nil
+	}
+	client, err := gomatrix.NewClient(s.matrix, "", token)
 	if err != nil {
 		return nil, err
 	}
-	return r.JoinedRooms, nil
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	url := client.BuildURL("/account/whoami")
+	if _, err := client.MakeRequest("GET", url, nil, &resp); err != nil {
+		if isUnknownToken(err) {
+			return s.tokens.setInvalid(token), nil
+		}
+		return nil, err
+	}
+	client.UserID = resp.UserID
+	return s.tokens.set(token, resp.UserID, client), nil
+}
+
+func getUser(c *gin.Context) string             { return c.MustGet(keyUser).(string) }
+func getClient(c *gin.Context) *gomatrix.Client { return c.MustGet(keyClient).(*gomatrix.Client) }
+func getRequest(c *gin.Context) interface{}     { return c.MustGet(keyRequest) }
+
+// getRooms returns the rooms joined by the current user.
+func (s *Server) getRooms(c *gin.Context) ([]string, error) {
+	return s.levels.joinedRooms(getUser(c), func() ([]string, error) {
+		r, err := getClient(c).JoinedRooms()
+		if err != nil {
+			return nil, err
+		}
+		return r.JoinedRooms, nil
+	})
 }
 
 func closeTransaction(tx *gorp.Transaction, err *error) {
@@ -174,19 +354,43 @@ type orgLevel struct {
 	Level int `json:"level"`
 }
 
-func getOrgLevel(c *gin.Context, org *database.Org) (*orgLevel, error) {
-	var p struct {
-		Users        map[string]int `json:"users"`
-		UsersDefault int            `json:"users_default"`
-	}
-	if err := getClient(c).StateEvent(org.RoomID, "m.room.power_levels", "", &p); err != nil {
+// getOrgLevel returns the power level of the current user in the Org.
+func (s *Server) getOrgLevel(c *gin.Context, org *database.Org) (*orgLevel, error) {
+	p, err := s.roomLevels(s.botClient(c), org.RoomID)
+	if err != nil {
 		return nil, err
 	}
-	lvl, ok := p.Users[getUser(c)]
+	return &orgLevel{org, p.level(getUser(c))}, nil
+}
+
+// roomLevels returns the power levels of a room, using client if they are not in cache.
+func (s *Server) roomLevels(client *gomatrix.Client, roomID string) (*powerLevels, error) {
+	return s.levels.levels(roomID, func() (*powerLevels, error) {
+		var p powerLevels
+		if err := client.StateEvent(roomID, "m.room.power_levels", "", &p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	})
+}
+
+// setLevel changes the power level of a user in a room, keeping the rest of the power levels.
+func (s *Server) setLevel(client *gomatrix.Client, roomID, userID string, level int) error {
+	var p map[string]interface{}
+	if err := client.StateEvent(roomID, "m.room.power_levels", "", &p); err != nil {
+		return err
+	}
+	users, ok := p["users"].(map[string]interface{})
 	if !ok {
-		lvl = p.UsersDefault
+		users = make(map[string]interface{})
+		p["users"] = users
+	}
+	users[userID] = level
+	if _, err := client.SendStateEvent(roomID, "m.room.power_levels", "", p); err != nil {
+		return err
 	}
-	return &orgLevel{org, lvl}, nil
+	s.levels.invalidateRoom(roomID)
+	return nil
 }
 
 func newULID() string {
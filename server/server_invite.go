@@ -0,0 +1,282 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/gomatrix"
+	"github.com/securityfirst/matrix-notifier/database"
+	"github.com/securityfirst/matrix-notifier/sender"
+)
+
+// DefaultInviteTTL is the default duration of an Invite.
+const DefaultInviteTTL = 7 * 24 * time.Hour
+
+// inviter sends the Invites.
+type inviter struct {
+	sender sender.Sender
+	ttl    time.Duration
+	link   string
+}
+
+// newInviter returns an inviter, Invites cannot be created without a Sender.
+func newInviter(s sender.Sender, ttl time.Duration, link string) *inviter {
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+	return &inviter{sender: s, ttl: ttl, link: link}
+}
+
+// message returns the subject and the body of the Invite email.
+func (i *inviter) message(org *database.Org, token string) (string, string) {
+	subject := fmt.Sprintf("Invitation to %s", org.Name)
+	if i.link == "" {
+		return subject, fmt.Sprintf("You have been invited to join %s.\n\nOrg: %s\nToken: %s\n", org.Name, org.Name, token)
+	}
+	link := fmt.Sprintf("%s?org=%s&token=%s", i.link, url.QueryEscape(org.Name), token)
+	return subject, fmt.Sprintf("You have been invited to join %s.\n\n%s\n", org.Name, link)
+}
+
+// send sends the Invite email with the token.
+func (i *inviter) send(org *database.Org, email, token string) error {
+	subject, body := i.message(org, token)
+	return i.sender.Send(email, subject, body)
+}
+
+// WithInvites sets the Sender, the duration of Invites and the link sent, that receives org and token in the query.
+func WithInvites(s sender.Sender, ttl time.Duration, link string) Option {
+	return func(srv *Server) { srv.invites = newInviter(s, ttl, link) }
+}
+
+// newToken returns a random token and its hash.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash of a token, that is stored instead of the token.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateInvite sends an Invite for the Org.
+func (s *Server) CreateInvite(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		if s.invites.sender == nil {
+			return ErrNoInviteSender
+		}
+		inv := getRequest(c).(*database.Invite)
+		addr, err := mail.ParseAddress(inv.Email)
+		if err != nil {
+			return ErrBadEmail
+		}
+		token, hash, err := newToken()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		inv.ID, inv.Token, inv.RoomID, inv.Email = newULID(), hash, org.RoomID, addr.Address
+		inv.CreatedBy, inv.CreatedAt, inv.ExpiresAt = getUser(c), now, now.Add(s.invites.ttl)
+		inv.UsedAt, inv.UsedBy, inv.RevokedAt = nil, "", nil
+		if err := database.Create(s.db, inv); err != nil {
+			return err
+		}
+		if err := s.invites.send(org.Org, inv.Email, token); err != nil {
+			database.RevokeInvite(s.db, org.RoomID, inv.ID, time.Now())
+			return err
+		}
+		c.JSON(http.StatusCreated, inv)
+		return nil
+	})
+}
+
+// ListInvites returns the pending Invites of the Org.
+func (s *Server) ListInvites(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		list, err := database.ListInvites(s.db, org.RoomID, time.Now())
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, list)
+		return nil
+	})
+}
+
+// RevokeInvite revokes a pending Invite of the Org.
+func (s *Server) RevokeInvite(name, id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		if err := database.RevokeInvite(s.db, org.RoomID, c.Param(id), time.Now()); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrInviteNotFound
+			}
+			return err
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// verifyRequest redeems an Invite token, creating a user if no access token is provided.
+type verifyRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// verifyResponse contains the access token only if a user was created.
+type verifyResponse struct {
+	RoomID      string `json:"room_id"`
+	UserID      string `json:"user_id"`
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// VerifyInvite redeems an Invite, adding the user to the Org with the Invite level.
+func (s *Server) VerifyInvite(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) (err error) {
+		if s.as == nil {
+			return ErrNoAppService
+		}
+		req := getRequest(c).(*verifyRequest)
+		inv, err := s.checkInvite(c.Param(name), req.Token)
+		if err != nil {
+			return err
+		}
+		// the Invite is claimed first, so that concurrent requests don't create users
+		if err := database.UseInvite(s.db, inv.ID, "", time.Now()); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrInviteUsed
+			}
+			return err
+		}
+		defer func() {
+			if err != nil {
+				database.ReleaseInvite(s.db, inv.ID)
+			}
+		}()
+		resp, client, err := s.inviteUser(c, req)
+		if err != nil {
+			return err
+		}
+		if err := database.SetInviteUser(s.db, inv.ID, resp.UserID); err != nil {
+			return err
+		}
+		if _, err := s.as.bot.InviteUser(inv.RoomID, &gomatrix.ReqInviteUser{UserID: resp.UserID}); err != nil {
+			return err
+		}
+		if _, err := client.JoinRoom(inv.RoomID, "", nil); err != nil {
+			return err
+		}
+		s.levels.invalidateUser(resp.UserID)
+		if inv.Admin {
+			if err := s.setLevel(s.as.bot, inv.RoomID, resp.UserID, LAdmin); err != nil {
+				return err
+			}
+		}
+		resp.RoomID = inv.RoomID
+		c.JSON(http.StatusOK, resp)
+		return nil
+	})
+}
+
+// checkInvite returns the pending Invite for the token, if it belongs to the Org.
+func (s *Server) checkInvite(name, token string) (*database.Invite, error) {
+	if token == "" {
+		return nil, ErrInviteNotFound
+	}
+	inv, err := database.GetInvite(s.db, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil || inv.RevokedAt != nil {
+		return nil, ErrInviteNotFound
+	}
+	v, err := s.db.Get(database.Org{}, inv.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || v.(*database.Org).Name != name {
+		return nil, ErrInviteNotFound
+	}
+	switch {
+	case inv.UsedAt != nil:
+		return nil, ErrInviteUsed
+	case time.Now().After(inv.ExpiresAt):
+		return nil, ErrInviteExpired
+	}
+	return inv, nil
+}
+
+// inviteUser returns the user of the access token provided, or registers a new one.
+func (s *Server) inviteUser(c *gin.Context, req *verifyRequest) (*verifyResponse, *gomatrix.Client, error) {
+	if token, err := s.getToken(c); err != ErrMissingToken {
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := s.whoami(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !e.valid() {
+			return nil, nil, ErrUnknownToken
+		}
+		return &verifyResponse{UserID: e.userID}, e.client, nil
+	}
+	if req.Username == "" || req.Password == "" {
+		return nil, nil, ErrMissingToken
+	}
+	client, err := gomatrix.NewClient(s.matrix, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := register(client, req.Username, req.Password)
+	if err != nil {
+		if e, ok := respError(err); ok {
+			return nil, nil, ErrorResponse{http.StatusForbidden, e.ErrCode, e.Err}
+		}
+		return nil, nil, err
+	}
+	client.SetCredentials(resp.UserID, resp.AccessToken)
+	return resp, client, nil
+}
+
+// register creates a user, or logs in if the user exists with the same password,
+// so that a failed verification can be retried after the user was registered.
+func register(client *gomatrix.Client, username, password string) (*verifyResponse, error) {
+	r, err := client.RegisterDummy(&gomatrix.ReqRegister{Username: username, Password: password})
+	if err == nil {
+		return &verifyResponse{UserID: r.UserID, AccessToken: r.AccessToken}, nil
+	}
+	if e, ok := respError(err); !ok || e.ErrCode != "M_USER_IN_USE" {
+		return nil, err
+	}
+	l, lerr := client.Login(&gomatrix.ReqLogin{Type: "m.login.password", User: username, Password: password})
+	if lerr != nil {
+		return nil, err
+	}
+	return &verifyResponse{UserID: l.UserID, AccessToken: l.AccessToken}, nil
+}
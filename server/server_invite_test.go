@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/gomatrix"
+	"github.com/securityfirst/matrix-notifier/database"
+	"github.com/securityfirst/matrix-notifier/sender"
+)
+
+func TestInviteMessage(t *testing.T) {
+	org := &database.Org{Name: "My Org"}
+	for _, tc := range []struct {
+		link, expected string
+	}{
+		{"", "Org: My Org\nToken: abc\n"},
+		{"https://app.example.com/join", "https://app.example.com/join?org=My+Org&token=abc\n"},
+	} {
+		m := &sender.Memory{}
+		if err := newInviter(m, 0, tc.link).send(org, "user@example.com", "abc"); err != nil {
+			t.Fatal(err)
+		}
+		msgs := m.Messages()
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(msgs))
+		}
+		if msg := msgs[0]; msg.To != "user@example.com" || msg.Subject != "Invitation to My Org" ||
+			!strings.HasSuffix(msg.Body, tc.expected) {
+			t.Errorf("unexpected message %#v", msg)
+		}
+	}
+}
+
+func TestRegisterExisting(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/r0/register":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errcode":"M_USER_IN_USE","error":"User ID already taken."}`))
+		case "/_matrix/client/r0/login":
+			w.Write([]byte(`{"user_id":"@user:hs","access_token":"token"}`))
+		}
+	}))
+	defer hs.Close()
+	client, err := gomatrix.NewClient(hs.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := register(client, "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.UserID != "@user:hs" || resp.AccessToken != "token" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
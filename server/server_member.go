@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/gomatrix"
+)
+
+// member is a user of an Org.
+type member struct {
+	UserID      string  `json:"user_id"`
+	DisplayName *string `json:"display_name,omitempty"`
+	Level       int     `json:"level"`
+}
+
+// memberRequest is the user to add to an Org.
+type memberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// levelRequest is the new level of a member.
+type levelRequest struct {
+	Level *int `json:"level"`
+}
+
+// ListMembers returns the members of the Org with their level.
+func (s *Server) ListMembers(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		resp, err := getClient(c).JoinedMembers(org.RoomID)
+		if err != nil {
+			return err
+		}
+		p, err := s.roomLevels(s.botClient(c), org.RoomID)
+		if err != nil {
+			return err
+		}
+		list := make([]member, 0, len(resp.Joined))
+		for id, m := range resp.Joined {
+			list = append(list, member{UserID: id, DisplayName: m.DisplayName, Level: p.level(id)})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+		c.JSON(http.StatusOK, list)
+		return nil
+	})
+}
+
+// AddMember invites a user to the Org.
+func (s *Server) AddMember(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		req := getRequest(c).(*memberRequest)
+		if req.UserID == "" {
+			return ErrBadUser
+		}
+		if _, err := getClient(c).InviteUser(org.RoomID, &gomatrix.ReqInviteUser{UserID: req.UserID}); err != nil {
+			return ErrBadUser.with(err)
+		}
+		c.Status(http.StatusCreated)
+		return nil
+	})
+}
+
+// RemoveMember kicks a user from the Org.
+func (s *Server) RemoveMember(name, user string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		userID := c.Param(user)
+		if _, err := getClient(c).KickUser(org.RoomID, &gomatrix.ReqKickUser{UserID: userID}); err != nil {
+			return ErrBadUser.with(err)
+		}
+		s.levels.invalidateUser(userID)
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// SetMemberLevel changes the power level of a member of the Org.
+func (s *Server) SetMemberLevel(name, user string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		org, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		req := getRequest(c).(*levelRequest)
+		if req.Level == nil {
+			return ErrBadLevel
+		}
+		if err := s.setLevel(getClient(c), org.RoomID, c.Param(user), *req.Level); err != nil {
+			return ErrBadLevel.with(err)
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
@@ -3,13 +3,29 @@ package server
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/securityfirst/matrix-notifier/database"
 )
 
-// ViewNotifications returns a list of notifications for the current user.
+// Pagination limits of the notification list.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	maxPollTimeout   = 5 * time.Minute
+)
+
+// notificationPage is a page of notifications, next_batch is empty on the last page.
+type notificationPage struct {
+	Chunk     []*database.Notification `json:"chunk"`
+	NextBatch string                   `json:"next_batch,omitempty"`
+}
+
+// ViewNotifications returns a page of notifications for the current user.
+// With a timeout, an empty page is returned only if no notification is created in the meantime.
 func (s *Server) ViewNotifications() gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
 		var since time.Time
@@ -20,27 +36,166 @@ func (s *Server) ViewNotifications() gin.HandlerFunc {
 			}
 			since = t
 		}
-		rooms, err := getRooms(c)
+		page, err := getPage(c)
+		if err != nil {
+			return err
+		}
+		timeout, err := getTimeout(c)
+		if err != nil {
+			return err
+		}
+		levels, err := s.getLevels(c)
 		if err != nil {
 			return err
 		}
-		levels := make(map[string]int, len(rooms))
-		for _, id := range rooms {
-			o, err := getOrgLevel(c, &database.Org{RoomID: id})
+		f := database.NotificationFilter{
+			UserID:     getUser(c),
+			Since:      since,
+			Levels:     levels,
+			Rules:      rulesView,
+			Superseded: c.Query("superseded") == "true",
+		}
+		if v := c.Query("min_priority"); v != "" {
+			p, err := database.ParsePriority(v)
 			if err != nil {
-				return err
+				return ErrBadPriority
 			}
-			levels[id] = o.Level
+			f.MinPriority = &p
 		}
-		list, err := database.ListNotifications(s.db, since, getUser(c), levels, rulesView)
+		page.Limit++
+		list, err := s.pollNotifications(c, f, page, timeout)
 		if err != nil {
 			return err
 		}
-		c.JSON(http.StatusOK, list)
+		resp := newPage(list, page)
+		s.trackDelivery(f.UserID, resp.Chunk)
+		c.JSON(http.StatusOK, resp)
 		return nil
 	})
 }
 
+// newPage returns the page of a list, selected with a limit increased by one to find the next batch.
+func newPage(list []*database.Notification, page database.Page) notificationPage {
+	resp := notificationPage{Chunk: list}
+	if limit := page.Limit - 1; uint64(len(list)) > limit {
+		resp.Chunk = list[:limit]
+		resp.NextBatch = pageToken(page, list[limit-1])
+	}
+	return resp
+}
+
+// pollNotifications returns a page of notifications, waiting up to timeout for new ones if it's empty.
+func (s *Server) pollNotifications(c *gin.Context, f database.NotificationFilter, page database.Page,
+	timeout time.Duration) ([]*database.Notification, error) {
+	var wake <-chan struct{}
+	if timeout > 0 {
+		l := s.hub.listen(roomIDs(f.Levels))
+		defer s.hub.close(l)
+		wake = l.wake
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		list, err := database.ListNotifications(s.db, f, page)
+		if err != nil || len(list) != 0 || timeout == 0 {
+			return list, err
+		}
+		select {
+		case <-wake:
+		case <-deadline.C:
+			return list, nil
+		case <-c.Request.Context().Done():
+			return list, nil
+		case <-s.ctx.Done():
+			return list, nil
+		}
+	}
+}
+
+// getTimeout parses the timeout parameter, in milliseconds.
+func getTimeout(c *gin.Context) (time.Duration, error) {
+	t := c.Query("timeout")
+	if t == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(t, 10, 32)
+	if err != nil {
+		return 0, ErrBadTimeout
+	}
+	if d := time.Duration(v) * time.Millisecond; d < maxPollTimeout {
+		return d, nil
+	}
+	return maxPollTimeout, nil
+}
+
+// getPage parses the from, limit, dir and order parameters.
+func getPage(c *gin.Context) (database.Page, error) {
+	p := database.Page{From: c.Query("from"), Limit: defaultPageLimit}
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.ParseUint(l, 10, 64)
+		if err != nil || v == 0 {
+			return p, ErrBadPage
+		}
+		if v > maxPageLimit {
+			v = maxPageLimit
+		}
+		p.Limit = v
+	}
+	switch c.DefaultQuery("dir", "f") {
+	case "f":
+	case "b":
+		p.Backward = true
+	default:
+		return p, ErrBadPage
+	}
+	switch c.DefaultQuery("order", "id") {
+	case "id":
+	case "priority":
+		p.ByPriority = true
+		if p.From == "" {
+			break
+		}
+		parts := strings.SplitN(p.From, "_", 2)
+		v, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return p, ErrBadPage
+		}
+		p.FromPriority, p.From = database.Priority(v), parts[1]
+	default:
+		return p, ErrBadPage
+	}
+	return p, nil
+}
+
+// pageToken returns the from parameter of the page after n.
+func pageToken(p database.Page, n *database.Notification) string {
+	if p.ByPriority {
+		return strconv.Itoa(int(n.Priority)) + "_" + n.ID
+	}
+	return n.ID
+}
+
+// getLevels returns the power level of the current user in each of its Orgs.
+func (s *Server) getLevels(c *gin.Context) (map[string]int, error) {
+	rooms, err := s.getRooms(c)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := database.ListOrgs(s.db, rooms...)
+	if err != nil {
+		return nil, err
+	}
+	levels := make(map[string]int, len(orgs))
+	for _, org := range orgs {
+		o, err := s.getOrgLevel(c, org)
+		if err != nil {
+			return nil, err
+		}
+		levels[org.RoomID] = o.Level
+	}
+	return levels, nil
+}
+
 func contains(s []string, v string) bool {
 	for _, a := range s {
 		if v == a {
@@ -53,62 +208,516 @@ func contains(s []string, v string) bool {
 // CreateNotification creates a new Notification.
 func (s *Server) CreateNotification() gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
-		n := c.MustGet("request").(*database.Notification)
-		rooms, err := getRooms(c)
+		if err := s.postNotification(c, c.MustGet("request").(*database.Notification)); err != nil {
+			return err
+		}
+		c.Status(http.StatusCreated)
+		return nil
+	})
+}
+
+// postNotification creates a Notification of the current user in one of its Orgs.
+func (s *Server) postNotification(c *gin.Context, n *database.Notification) error {
+	rooms, err := s.getRooms(c)
+	if err != nil {
+		return err
+	}
+	if !contains(rooms, n.RoomID) {
+		return ErrUnknownOrg
+	}
+	v, err := s.db.Get(database.Org{}, n.RoomID)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return ErrUnknownOrg
+	}
+	orgLvl, err := s.getOrgLevel(c, v.(*database.Org))
+	if err != nil {
+		return err
+	}
+	if err := s.checkRecipients(c, n); err != nil {
+		return err
+	}
+	n.UserID = getUser(c)
+	return s.createNotification(n, orgLvl.Level)
+}
+
+// checkRecipients removes the duplicate recipients and checks that they are members of the room.
+func (s *Server) checkRecipients(c *gin.Context, n *database.Notification) error {
+	if n.MinLevel != nil && n.MaxLevel != nil && *n.MinLevel > *n.MaxLevel {
+		return ErrBadRecipient
+	}
+	if len(n.Recipients) == 0 {
+		return nil
+	}
+	resp, err := s.botClient(c).JoinedMembers(n.RoomID)
+	if err != nil {
+		return err
+	}
+	list := n.Recipients[:0]
+	for _, r := range n.Recipients {
+		if _, ok := resp.Joined[r]; !ok {
+			return ErrBadRecipient
+		}
+		if !contains(list, r) {
+			list = append(list, r)
+		}
+	}
+	n.Recipients = list
+	return nil
+}
+
+// createNotification checks the rules for the author level, then validates and saves the Notification.
+func (s *Server) createNotification(n *database.Notification, level int) error {
+	min, ok := rulesCreate[n.Type]
+	if !ok {
+		return ErrUnknownType
+	}
+	if min > level {
+		return ErrUnauthorized
+	}
+	if min, ok := rulesPriority[n.Priority]; !ok {
+		return ErrBadPriority
+	} else if min > level {
+		return ErrUnauthorized
+	}
+	n.ID, n.CreatedAt = newULID(), time.Now()
+	// the fields managed by the server are not taken from the request
+	n.EditedAt, n.Retracted, n.ClosedAt = nil, false, nil
+	n.AckedAt, n.AckedBy, n.ResolvedAt, n.Escalation = nil, "", nil, 0
+	if n.Content != nil {
+		n.CollapseKey, n.RefID = n.Content.CollapseKey, n.Content.RefID
+	}
+	if n.SendAt != nil && !n.SendAt.After(n.CreatedAt) {
+		n.SendAt = nil
+	}
+	if n.ExpiresAt != nil && !n.ExpiresAt.After(n.CreatedAt) {
+		return ErrBadExpiry
+	}
+	if n.Type == NPoll {
+		n.ClosesAt = pollClosesAt(n.Content)
+	}
+	if n.SendAt != nil {
+		if err := checkSendAt(n, *n.SendAt); err != nil {
+			return err
+		}
+	}
+	ref, err := s.validateNotification(n)
+	if err != nil {
+		return err
+	}
+	if ref != nil {
+		if ref.Recipients, err = database.Recipients(s.db, ref.ID); err != nil {
+			return err
+		}
+		if !ref.Receives(n.UserID, level) {
+			return ErrReferenceNotFound
+		}
+	}
+	if n.Type == NVote {
+		return s.saveVote(n)
+	}
+	return s.saveNotification(n)
+}
+
+// checkSendAt checks that a Notification sent at t does not expire, or close if a Poll, before.
+func checkSendAt(n *database.Notification, t time.Time) error {
+	if n.ExpiresAt != nil && !n.ExpiresAt.After(t) {
+		return ErrBadExpiry
+	}
+	if n.Type == NPoll && n.ClosesAt != nil && !n.ClosesAt.After(t) {
+		return ErrBadPoll
+	}
+	return nil
+}
+
+// saveNotification creates the Notification, its Recipients and its Mirror, then delivers it.
+// Scheduled Notifications are delivered when published, targeted ones are not mirrored.
+func (s *Server) saveNotification(n *database.Notification) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil && n.SendAt == nil {
+			s.delivered(n)
+		}
+	}()
+	return s.insertNotification(tx, n)
+}
+
+// insertNotification creates a Notification with its Recipients, and its Mirror if needed.
+func (s *Server) insertNotification(tx database.DB, n *database.Notification) error {
+	if err := database.Create(tx, n); err != nil {
+		return err
+	}
+	for _, r := range n.Recipients {
+		if err := database.Create(tx, &database.NotificationRecipient{NotificationID: n.ID, UserID: r}); err != nil {
+			return err
+		}
+	}
+	if n.Type == NPanic && n.SendAt == nil {
+		if err := addPanicEvent(tx, n, database.EventSent, n.UserID, n.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if s.mirror != nil && n.SendAt == nil && !n.Targeted() && !n.Anonymous {
+		return database.Create(tx, &database.Mirror{NotificationID: n.ID, RoomID: n.RoomID, NextAttempt: n.CreatedAt})
+	}
+	return nil
+}
+
+// delivered wakes up the Mirror and the listeners after a Notification is visible.
+func (s *Server) delivered(n *database.Notification) {
+	if s.mirror != nil {
+		s.mirror.notify()
+	}
+	s.hub.publish(n.RoomID)
+}
+
+// validateNotification checks the options of a Poll or a Question, and the reference of an Answer or a Vote,
+// that is returned.
+func (s *Server) validateNotification(n *database.Notification) (*database.Notification, error) {
+	switch n.Type {
+	case NPoll:
+		return nil, validatePoll(n.Content, time.Now())
+	case NQuestion:
+		return nil, validateQuestion(n.Content)
+	case NAnswer, NVote:
+	default:
+		return nil, nil
+	}
+	if n.Content == nil || n.Content.RefID == "" {
+		return nil, ErrMissingReference
+	}
+	v, err := database.Get(s.db, database.Notification{}, n.Content.RefID)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrReferenceNotFound
+	}
+	q := v.(*database.Notification)
+	if q.RoomID != n.RoomID || q.SendAt != nil || q.Retracted || q.ArchivedAt != nil ||
+		n.Type == NAnswer && q.Type != NQuestion ||
+		n.Type == NVote && q.Type != NPoll {
+		return nil, ErrReferenceNotFound
+	}
+	if n.Type == NAnswer && answersOption(q.Content) == database.AnswersChoices && !hasChoice(q.Content, n.Content.Selected) {
+		return nil, ErrBadChoice
+	}
+	if n.Type == NVote {
+		if isClosed(q, time.Now()) {
+			return nil, ErrPollClosed
+		}
+		if err := checkVote(q.Content, n.Content); err != nil {
+			return nil, err
+		}
+		n.Anonymous = q.Content.Poll != nil && q.Content.Poll.Anonymous
+	}
+	return q, nil
+}
+
+// ReadNotifications marks as read all the notifications of the current user.
+func (s *Server) ReadNotifications() gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		if err := s.readAll(c); err != nil {
+			return err
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// readAll marks as read all the notifications visible to the current user.
+func (s *Server) readAll(c *gin.Context) error {
+	levels, err := s.getLevels(c)
+	if err != nil {
+		return err
+	}
+	return database.MarkAsRead(s.db, getUser(c), time.Now(), levels, rulesView)
+}
+
+// getVisibleNotification returns a Notification if the current user can see it, and the user level in its Org.
+func (s *Server) getVisibleNotification(c *gin.Context, id string) (*database.Notification, int, error) {
+	v, err := database.Get(s.db, database.Notification{}, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v == nil {
+		return nil, 0, ErrNotificationNotFound
+	}
+	n := v.(*database.Notification)
+	levels, err := s.getLevels(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	lvl, ok := levels[n.RoomID]
+	if min, known := rulesView[n.Type]; !ok || !known || n.ArchivedAt != nil || n.SendAt != nil ||
+		n.ExpiresAt != nil && !n.ExpiresAt.After(time.Now()) {
+		return nil, 0, ErrNotificationNotFound
+	} else if lvl < min {
+		if ok, err := s.isAnswerReader(n, getUser(c)); err != nil {
+			return nil, 0, err
+		} else if !ok {
+			return nil, 0, ErrNotificationNotFound
+		}
+	}
+	if n.Recipients, err = database.Recipients(s.db, n.ID); err != nil {
+		return nil, 0, err
+	}
+	if !n.Receives(getUser(c), lvl) || n.Anonymous && n.UserID != getUser(c) {
+		return nil, 0, ErrNotificationNotFound
+	}
+	return n, lvl, nil
+}
+
+// isAnswerReader is true if the Notification is an Answer of the user, or to a Question of the user.
+func (s *Server) isAnswerReader(n *database.Notification, userID string) (bool, error) {
+	if n.Type != NAnswer {
+		return false, nil
+	}
+	if n.UserID == userID {
+		return true, nil
+	}
+	v, err := database.Get(s.db, database.Notification{}, n.RefID)
+	if err != nil || v == nil {
+		return false, err
+	}
+	return v.(*database.Notification).UserID == userID, nil
+}
+
+// ReadNotification marks a notification as read, or all of them if the ID is "all".
+func (s *Server) ReadNotification(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		if err := s.readNotification(c, c.Param(id)); err != nil {
+			return err
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// readNotification marks a notification as read, or all of them if the ID is "all".
+func (s *Server) readNotification(c *gin.Context, id string) error {
+	if id == "all" {
+		return s.readAll(c)
+	}
+	n, _, err := s.getVisibleNotification(c, id)
+	if err != nil {
+		return err
+	}
+	return database.MarkNotificationRead(s.db, n.ID, getUser(c), time.Now())
+}
+
+// UnreadNotification marks a notification as unread.
+func (s *Server) UnreadNotification(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		if err := s.unreadNotification(c, c.Param(id)); err != nil {
+			return err
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// unreadNotification marks a notification as unread.
+func (s *Server) unreadNotification(c *gin.Context, id string) error {
+	n, _, err := s.getVisibleNotification(c, id)
+	if err != nil {
+		return err
+	}
+	return database.MarkNotificationUnread(s.db, n.ID, getUser(c))
+}
+
+// ListScheduled returns the scheduled notifications of the current user.
+func (s *Server) ListScheduled() gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		list, err := database.ListScheduled(s.db, getUser(c))
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, list)
+		return nil
+	})
+}
+
+type scheduleRequest struct {
+	SendAt *time.Time `json:"send_at"`
+}
+
+// Reschedule changes the time of a scheduled notification of the current user,
+// that must still be sent before it expires, or closes for a Poll.
+func (s *Server) Reschedule(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		r := getRequest(c).(*scheduleRequest)
+		if r.SendAt == nil {
+			return ErrBadTimestamp
+		}
+		v, err := database.Get(s.db, database.Notification{}, c.Param(id))
+		if err != nil {
+			return err
+		}
+		n, ok := v.(*database.Notification)
+		if !ok || n.UserID != getUser(c) || n.SendAt == nil {
+			return ErrNotificationNotFound
+		}
+		if err := checkSendAt(n, *r.SendAt); err != nil {
+			return err
+		}
+		err = database.RescheduleNotification(s.db, n.ID, n.UserID, *r.SendAt)
+		if err == sql.ErrNoRows {
+			return ErrNotificationNotFound
+		}
 		if err != nil {
 			return err
 		}
-		if !contains(rooms, n.RoomID) {
-			return ErrUnknownOrg
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+// CancelScheduled deletes a scheduled notification of the current user.
+func (s *Server) CancelScheduled(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		err := database.CancelNotification(s.db, c.Param(id), getUser(c))
+		if err == sql.ErrNoRows {
+			return ErrNotificationNotFound
 		}
-		v, err := s.db.Get(database.Org{}, n.RoomID)
 		if err != nil {
 			return err
 		}
-		orgLvl, err := getOrgLevel(c, v.(*database.Org))
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
+
+type editRequest struct {
+	Content *database.Content `json:"content"`
+}
+
+// EditNotification replaces the content of a notification, keeping the previous one in its history.
+func (s *Server) EditNotification(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		req := getRequest(c).(*editRequest)
+		if req.Content == nil {
+			return ErrBadJSON
+		}
+		n, err := s.getEditableNotification(c, c.Param(id))
 		if err != nil {
 			return err
 		}
-		if rulesCreate[n.RoomID] > orgLvl.Level {
-			return ErrUnauthorized
+		if n.Content != nil {
+			req.Content.CollapseKey, req.Content.RefID = n.Content.CollapseKey, n.Content.RefID
+		}
+		if n.Type == NPoll {
+			if n.ClosedAt != nil {
+				return ErrPollClosed
+			}
+			if isAnonymous(n.Content) != isAnonymous(req.Content) {
+				return ErrBadPoll
+			}
 		}
-		n.ID, n.UserID, n.CreatedAt = newULID(), getUser(c), time.Now()
-		if err := s.validateNotification(n); err != nil {
+		edit := *n
+		edit.Content = req.Content
+		if _, err := s.validateNotification(&edit); err != nil {
 			return err
 		}
-		if err := database.Create(s.db, n); err != nil {
+		if err := s.editNotification(n, getUser(c), req.Content, false); err != nil {
 			return err
 		}
-		c.Status(http.StatusCreated)
+		c.JSON(http.StatusOK, n)
 		return nil
 	})
 }
 
-func (s *Server) validateNotification(n *database.Notification) error {
-	if n.Type != NAnswer && n.Type != NVote {
+// RetractNotification removes the content of a notification, keeping it in its history.
+// The Votes of a closed Poll cannot be retracted.
+func (s *Server) RetractNotification(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, err := s.getEditableNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if n.Type == NVote {
+			v, err := database.Get(s.db, database.Notification{}, n.RefID)
+			if err != nil {
+				return err
+			}
+			if p, ok := v.(*database.Notification); ok && isClosed(p, time.Now()) {
+				return ErrPollClosed
+			}
+		}
+		if err := s.editNotification(n, getUser(c), nil, true); err != nil {
+			return err
+		}
+		c.Status(http.StatusNoContent)
 		return nil
+	})
+}
+
+// getEditableNotification returns a Notification if the current user is its author or a moderator.
+// Votes can be changed only by their author.
+func (s *Server) getEditableNotification(c *gin.Context, id string) (*database.Notification, error) {
+	n, lvl, err := s.getVisibleNotification(c, id)
+	if err != nil {
+		return nil, err
 	}
-	if n.Content == nil || n.Content.RefID == "" {
-		return ErrMissingReference
+	if n.Retracted {
+		return nil, ErrRetracted
 	}
-	q := database.Notification{}
-	if _, err := database.Get(s.db, &q, n.Content.RefID); err != nil {
-		if err == sql.ErrNoRows {
-			return ErrReferenceNotFound
+	if n.UserID != getUser(c) && (lvl < LMod || n.Type == NVote) {
+		return nil, ErrUnauthorized
+	}
+	return n, nil
+}
+
+// editNotification saves the previous content of the Notification and replaces it, then updates its Mirror.
+func (s *Server) editNotification(n *database.Notification, userID string, content *database.Content, retract bool) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil {
+			s.delivered(n)
 		}
+	}()
+	now := time.Now()
+	edit := database.NotificationEdit{ID: newULID(), NotificationID: n.ID, UserID: userID, CreatedAt: now, Content: n.Content}
+	if err = database.Create(tx, &edit); err != nil {
 		return err
 	}
-	if q.RoomID != n.RoomID ||
-		q.Type == NQuestion && n.Type != NAnswer ||
-		q.Type == NPoll && n.Type != NVote {
-		return ErrReferenceNotFound
+	n.Content, n.EditedAt, n.Retracted = content, &now, retract
+	if n.Type == NPoll {
+		n.ClosesAt = pollClosesAt(content)
 	}
-	return nil
+	if err = database.EditNotification(tx, n); err == sql.ErrNoRows {
+		return ErrRetracted
+	}
+	if err != nil {
+		return err
+	}
+	return s.updateMirror(tx, n, edit.ID, now)
 }
 
-// ReadNotifications updates the read notificaitons.
-func (s *Server) ReadNotifications() gin.HandlerFunc {
-	return handler(func(c *gin.Context) error {
-		return database.MarkAsRead(s.db, getUser(c), time.Now())
-	})
+// updateMirror schedules the edit, or the redaction, of the event of a mirrored Notification.
+// A Mirror not sent yet sends the current content, or is dropped if retracted.
+func (s *Server) updateMirror(tx database.DB, n *database.Notification, updateID string, t time.Time) error {
+	if s.mirror == nil {
+		return nil
+	}
+	v, err := database.Get(tx, database.Mirror{}, n.ID)
+	if err != nil || v == nil {
+		return err
+	}
+	m := v.(*database.Mirror)
+	if m.EventID == "" {
+		return nil
+	}
+	m.UpdateID, m.Attempts, m.NextAttempt = updateID, 0, t
+	return database.Update(tx, m)
 }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestGetPage(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		expected database.Page
+		err      error
+	}{
+		{"", database.Page{Limit: defaultPageLimit}, nil},
+		{"from=01ABC&limit=10&dir=b", database.Page{From: "01ABC", Limit: 10, Backward: true}, nil},
+		{"limit=100000", database.Page{Limit: maxPageLimit}, nil},
+		{"limit=0", database.Page{}, ErrBadPage},
+		{"limit=-1", database.Page{}, ErrBadPage},
+		{"dir=x", database.Page{}, ErrBadPage},
+		{"order=priority&from=-1_01ABC", database.Page{From: "01ABC", Limit: defaultPageLimit, ByPriority: true,
+			FromPriority: database.PriorityLow}, nil},
+		{"order=priority&from=01ABC", database.Page{}, ErrBadPage},
+		{"order=x", database.Page{}, ErrBadPage},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+tc.query, nil)
+		p, err := getPage(c)
+		if err != tc.err {
+			t.Errorf("%q: expected error %v, got %v", tc.query, tc.err, err)
+			continue
+		}
+		if err == nil && p != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.query, tc.expected, p)
+		}
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		expected time.Duration
+		err      error
+	}{
+		{"", 0, nil},
+		{"timeout=30000", 30 * time.Second, nil},
+		{"timeout=99999999", maxPollTimeout, nil},
+		{"timeout=-1", 0, ErrBadTimeout},
+		{"timeout=1s", 0, ErrBadTimeout},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+tc.query, nil)
+		d, err := getTimeout(c)
+		if err != tc.err || d != tc.expected {
+			t.Errorf("%q: expected %s %v, got %s %v", tc.query, tc.expected, tc.err, d, err)
+		}
+	}
+}
+
+func TestCheckSendAt(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	for i, tc := range []struct {
+		n   database.Notification
+		err error
+	}{
+		{database.Notification{}, nil},
+		{database.Notification{ExpiresAt: &later}, nil},
+		{database.Notification{ExpiresAt: &now}, ErrBadExpiry},
+		{database.Notification{Type: NPoll, ClosesAt: &later}, nil},
+		{database.Notification{Type: NPoll, ClosesAt: &now}, ErrBadPoll},
+	} {
+		if err := checkSendAt(&tc.n, now); err != tc.err {
+			t.Errorf("%d: expected error %v, got %v", i, tc.err, err)
+		}
+	}
+}
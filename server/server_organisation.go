@@ -1,7 +1,11 @@
 package server
 
 import (
+	"database/sql"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/matrix-org/gomatrix"
 
@@ -12,7 +16,7 @@ import (
 // ListOrgs returns Orgs for the current User.
 func (s *Server) ListOrgs() gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
-		rooms, err := getRooms(c)
+		rooms, err := s.getRooms(c)
 		if err != nil {
 			return err
 		}
@@ -22,7 +26,7 @@ func (s *Server) ListOrgs() gin.HandlerFunc {
 		}
 		list := make([]*orgLevel, len(orgs))
 		for i := range orgs {
-			list[i], err = getOrgLevel(c, orgs[i])
+			list[i], err = s.getOrgLevel(c, orgs[i])
 			if err != nil {
 				return err
 			}
@@ -35,7 +39,7 @@ func (s *Server) ListOrgs() gin.HandlerFunc {
 // GetOrgByName returns an Org.
 func (s *Server) GetOrgByName(name string) gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
-		rooms, err := getRooms(c)
+		rooms, err := s.getRooms(c)
 		if err != nil {
 			return err
 		}
@@ -43,7 +47,7 @@ func (s *Server) GetOrgByName(name string) gin.HandlerFunc {
 		if err != nil {
 			return err
 		}
-		orgLvl, err := getOrgLevel(c, org)
+		orgLvl, err := s.getOrgLevel(c, org)
 		if err != nil {
 			return err
 		}
@@ -52,19 +56,45 @@ func (s *Server) GetOrgByName(name string) gin.HandlerFunc {
 	})
 }
 
+// getAdminOrg returns the Org if the current user is an admin.
+func (s *Server) getAdminOrg(c *gin.Context, name string) (*orgLevel, error) {
+	rooms, err := s.getRooms(c)
+	if err != nil {
+		return nil, err
+	}
+	org, err := database.GetOrgByName(s.db, name, rooms...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownOrg
+		}
+		return nil, err
+	}
+	orgLvl, err := s.getOrgLevel(c, org)
+	if err != nil {
+		return nil, err
+	}
+	if orgLvl.Level < LAdmin {
+		return nil, ErrUnauthorized
+	}
+	return orgLvl, nil
+}
+
 // CreateOrg returns an Org.
 func (s *Server) CreateOrg() gin.HandlerFunc {
 	return handler(func(c *gin.Context) error {
-		req, client := getRequest(c).(*database.Org), getClient(c)
-		room, err := client.CreateRoom(&gomatrix.ReqCreateRoom{
-			Visibility:    "private",
-			Name:          req.Name,
-			RoomAliasName: req.Name,
-		})
+		req, client := getRequest(c).(*database.Org), s.botClient(c)
+		if req.Retention < 0 {
+			return ErrBadRetention
+		}
+		if err := validateEscalation(req.Escalation); err != nil {
+			return err
+		}
+		room, err := s.createRoom(c, req)
 		if err != nil {
 			return err
 		}
 		req.RoomID = room.RoomID
+		s.levels.invalidateUser(getUser(c))
 		defer func(err *error) {
 			if *err != nil {
 				client.ForgetRoom(room.RoomID)
@@ -85,3 +115,202 @@ func (s *Server) CreateOrg() gin.HandlerFunc {
 		return nil
 	})
 }
+
+// reqCreateRoom is a gomatrix.ReqCreateRoom with the power levels override.
+type reqCreateRoom struct {
+	gomatrix.ReqCreateRoom
+	PowerLevels *powerLevels `json:"power_level_content_override,omitempty"`
+}
+
+// createRoom creates the Org room. As an Application Service the room is owned by the bot,
+// and the current user is invited and joined as admin.
+func (s *Server) createRoom(c *gin.Context, org *database.Org) (*gomatrix.RespCreateRoom, error) {
+	req := reqCreateRoom{ReqCreateRoom: gomatrix.ReqCreateRoom{
+		Visibility:    "private",
+		Name:          org.Name,
+		RoomAliasName: org.Name,
+	}}
+	if s.as == nil {
+		return getClient(c).CreateRoom(&req.ReqCreateRoom)
+	}
+	bot, user := s.as.bot, getUser(c)
+	req.Invite = []string{user}
+	req.PowerLevels = &powerLevels{Users: map[string]int{bot.UserID: LAdmin, user: LAdmin}}
+	var room gomatrix.RespCreateRoom
+	if _, err := bot.MakeRequest("POST", bot.BuildURL("createRoom"), &req, &room); err != nil {
+		return nil, err
+	}
+	if _, err := getClient(c).JoinRoom(room.RoomID, "", nil); err != nil {
+		bot.LeaveRoom(room.RoomID)
+		return nil, err
+	}
+	return &room, nil
+}
+
+// orgUpdate contains the Org fields to update.
+type orgUpdate struct {
+	Name       *string  `json:"name"`
+	Package    *string  `json:"package"`
+	Intent     *string  `json:"intent"`
+	Retention  *int     `json:"retention"`
+	Escalation *[]int64 `json:"escalation"`
+}
+
+// UpdateOrg updates an Org, keeping the room name and alias in sync.
+func (s *Server) UpdateOrg(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) (err error) {
+		orgLvl, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		req, org, old := getRequest(c).(*orgUpdate), *orgLvl.Org, orgLvl.Name
+		for _, f := range []struct{ v, dst *string }{{req.Name, &org.Name}, {req.Package, &org.Package}, {req.Intent, &org.Intent}} {
+			if f.v != nil {
+				*f.dst = *f.v
+			}
+		}
+		if org.Name == "" {
+			return ErrBadJSON
+		}
+		if req.Retention != nil {
+			if *req.Retention < 0 {
+				return ErrBadRetention
+			}
+			org.Retention = *req.Retention
+		}
+		if req.Escalation != nil {
+			if err := validateEscalation(*req.Escalation); err != nil {
+				return err
+			}
+			org.Escalation = *req.Escalation
+		}
+		// the update is committed only if the room is renamed, the room is restored if the commit fails
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := database.UpdateOrg(tx, &org); err != nil {
+			tx.Rollback()
+			if database.IsDuplicate(err) {
+				return ErrOrgExists
+			}
+			return err
+		}
+		client := s.botClient(c)
+		if org.Name != old {
+			if err := s.renameRoom(client, org.RoomID, old, org.Name); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			if org.Name != old {
+				if err := s.renameRoom(client, org.RoomID, org.Name, old); err != nil {
+					log.Printf("Restoring room %s: %s", org.RoomID, err)
+				}
+			}
+			return err
+		}
+		c.JSON(http.StatusOK, &orgLevel{&org, orgLvl.Level})
+		return nil
+	})
+}
+
+// renameRoom changes name and canonical alias of a room, the old alias is removed.
+// The previous name and alias are restored if a step fails.
+func (s *Server) renameRoom(client *gomatrix.Client, roomID, old, name string) (err error) {
+	alias := func(name string) string { return roomAlias(roomID, name) }
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				log.Printf("Restoring room %s: %s", roomID, err)
+			}
+		}
+	}()
+	if _, err := client.SendStateEvent(roomID, "m.room.name", "", map[string]string{"name": name}); err != nil {
+		return err
+	}
+	undo = append(undo, func() error {
+		_, err := client.SendStateEvent(roomID, "m.room.name", "", map[string]string{"name": old})
+		return err
+	})
+	url := client.BuildURL("directory", "room", alias(name))
+	if _, err := client.MakeRequest("PUT", url, map[string]string{"room_id": roomID}, nil); err != nil {
+		return err
+	}
+	undo = append(undo, func() error {
+		_, err := client.MakeRequest("DELETE", url, nil, nil)
+		return err
+	})
+	if _, err := client.SendStateEvent(roomID, "m.room.canonical_alias", "", map[string]string{"alias": alias(name)}); err != nil {
+		return err
+	}
+	if _, err := client.MakeRequest("DELETE", client.BuildURL("directory", "room", alias(old)), nil, nil); err != nil {
+		log.Printf("Removing alias %s: %s", alias(old), err)
+	}
+	return nil
+}
+
+// roomAlias returns the alias of an Org room.
+func roomAlias(roomID, name string) string {
+	return "#" + name + ":" + roomServer(roomID)
+}
+
+// roomServer returns the server name of a room ID.
+func roomServer(roomID string) string {
+	if i := strings.Index(roomID, ":"); i >= 0 {
+		return roomID[i+1:]
+	}
+	return ""
+}
+
+// DeleteOrg deletes an Org and archives its Notifications.
+// With purge=true Notifications are deleted, with tombstone=true the room is tombstoned.
+// The room alias is removed.
+// Only users with the highest power level of the room are allowed.
+func (s *Server) DeleteOrg(name string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) (err error) {
+		orgLvl, err := s.getAdminOrg(c, c.Param(name))
+		if err != nil {
+			return err
+		}
+		client := s.botClient(c)
+		p, err := s.roomLevels(client, orgLvl.RoomID)
+		if err != nil {
+			return err
+		}
+		if orgLvl.Level < p.max() {
+			return ErrUnauthorized
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer closeTransaction(tx, &err)
+		if err = database.DeleteOrg(tx, orgLvl.RoomID, c.Query("purge") == "true", time.Now()); err != nil {
+			return err
+		}
+		// the alias is removed, so that a new Org can have the same name
+		url := client.BuildURL("directory", "room", roomAlias(orgLvl.RoomID, orgLvl.Name))
+		if _, err = client.MakeRequest("DELETE", url, nil, nil); err != nil {
+			if e, ok := respError(err); !ok || e.ErrCode != "M_NOT_FOUND" {
+				return err
+			}
+		}
+		if c.Query("tombstone") == "true" {
+			content := map[string]string{"body": "This Org has been deleted"}
+			if _, err = client.SendStateEvent(orgLvl.RoomID, "m.room.tombstone", "", content); err != nil {
+				if _, err := client.MakeRequest("PUT", url, map[string]string{"room_id": orgLvl.RoomID}, nil); err != nil {
+					log.Printf("Restoring alias of room %s: %s", orgLvl.RoomID, err)
+				}
+				return err
+			}
+		}
+		c.Status(http.StatusNoContent)
+		return nil
+	})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// escalationLevel returns the minimum level reached by an escalation step, and the Panic priority.
+func escalationLevel(step int) (int, database.Priority) {
+	if step <= 1 {
+		return LMod, database.PriorityHigh
+	}
+	return LAdmin, database.PriorityCritical
+}
+
+// validateEscalation checks that the escalation delays are positive and increasing.
+func validateEscalation(delays []int64) error {
+	for i, d := range delays {
+		if d <= 0 || i > 0 && d <= delays[i-1] {
+			return ErrBadEscalation
+		}
+	}
+	return nil
+}
+
+func addPanicEvent(d database.DB, n *database.Notification, typ, userID string, t time.Time) error {
+	return database.Create(d, &database.PanicEvent{
+		ID: newULID(), NotificationID: n.ID, Type: typ, UserID: userID, CreatedAt: t,
+	})
+}
+
+// trackDelivery records the delivery of the Panics of a list to the user.
+func (s *Server) trackDelivery(userID string, list []*database.Notification) {
+	for _, n := range list {
+		if n.Type != NPanic || n.UserID == userID {
+			continue
+		}
+		e := database.PanicEvent{ID: newULID(), NotificationID: n.ID, UserID: userID, CreatedAt: time.Now()}
+		if err := database.AddDelivery(s.db, &e); err != nil {
+			log.Println("Delivery error:", err)
+		}
+	}
+}
+
+// escalateDue escalates the unacknowledged Panics that reached the next delay of their Org.
+func (s *Server) escalateDue(t time.Time) error {
+	for {
+		list, err := database.DuePanics(s.db, t, scheduleBatch)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			if err := s.escalatePanic(n, t); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if len(list) < scheduleBatch {
+			return nil
+		}
+	}
+}
+
+// escalatePanic raises the priority of a Panic, and notifies the members with the level of the next step.
+func (s *Server) escalatePanic(n *database.Notification, t time.Time) (err error) {
+	level, priority := escalationLevel(n.Escalation + 1)
+	if priority < n.Priority {
+		priority = n.Priority
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	e := &database.Notification{
+		ID: newULID(), RoomID: n.RoomID, UserID: n.UserID, Priority: priority, CreatedAt: t, Type: NEscalation,
+		RefID: n.ID, MinLevel: &level,
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil {
+			s.delivered(e)
+		}
+	}()
+	if err = database.EscalatePanic(tx, n, priority); err != nil {
+		return err
+	}
+	e.Content = &database.Content{RefID: n.ID}
+	if n.Content != nil {
+		e.Content.Text = n.Content.Text
+	}
+	if err = s.insertNotification(tx, e); err != nil {
+		return err
+	}
+	return database.Create(tx, &database.PanicEvent{
+		ID: newULID(), NotificationID: n.ID, Type: database.EventEscalated, Level: &level, CreatedAt: t,
+	})
+}
+
+// getPanic returns a visible Panic, with the level of the current user.
+func (s *Server) getPanic(c *gin.Context, id string) (*database.Notification, int, error) {
+	n, lvl, err := s.getVisibleNotification(c, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n.Type != NPanic {
+		return nil, 0, ErrNotificationNotFound
+	}
+	if n.Retracted {
+		return nil, 0, ErrRetracted
+	}
+	return n, lvl, nil
+}
+
+// AckPanic acknowledges a Panic (moderator), stopping its escalation.
+func (s *Server) AckPanic(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, lvl, err := s.getPanic(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if lvl < LMod {
+			return ErrUnauthorized
+		}
+		err = s.updatePanic(n, database.EventAcked, getUser(c), func(tx database.DB, t time.Time) error {
+			return database.AckPanic(tx, n, getUser(c), t)
+		})
+		if err == sql.ErrNoRows {
+			return ErrPanicAcked
+		}
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, n)
+		return nil
+	})
+}
+
+// ResolvePanic marks a Panic as resolved (author or moderator), stopping its escalation.
+func (s *Server) ResolvePanic(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, lvl, err := s.getPanic(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if n.UserID != getUser(c) && lvl < LMod {
+			return ErrUnauthorized
+		}
+		err = s.updatePanic(n, database.EventResolved, getUser(c), func(tx database.DB, t time.Time) error {
+			return database.ResolvePanic(tx, n, t)
+		})
+		if err == sql.ErrNoRows {
+			return ErrPanicResolved
+		}
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, n)
+		return nil
+	})
+}
+
+// updatePanic changes the state of a Panic and records the event in its timeline.
+func (s *Server) updatePanic(n *database.Notification, typ, userID string, update func(database.DB, time.Time) error) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil {
+			s.hub.publish(n.RoomID)
+		}
+	}()
+	now := time.Now()
+	if err = update(tx, now); err != nil {
+		return err
+	}
+	return addPanicEvent(tx, n, typ, userID, now)
+}
+
+// PanicTimeline returns the events of a Panic (author or moderator).
+func (s *Server) PanicTimeline(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, lvl, err := s.getVisibleNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if n.Type != NPanic {
+			return ErrNotificationNotFound
+		}
+		if n.UserID != getUser(c) && lvl < LMod {
+			return ErrUnauthorized
+		}
+		list, err := database.PanicTimeline(s.db, n.ID)
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, list)
+		return nil
+	})
+}
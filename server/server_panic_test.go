@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestValidateEscalation(t *testing.T) {
+	for i, tc := range []struct {
+		delays []int64
+		err    error
+	}{
+		{nil, nil},
+		{[]int64{300}, nil},
+		{[]int64{300, 900}, nil},
+		{[]int64{0}, ErrBadEscalation},
+		{[]int64{300, 300}, ErrBadEscalation},
+		{[]int64{900, 300}, ErrBadEscalation},
+	} {
+		if err := validateEscalation(tc.delays); err != tc.err {
+			t.Errorf("%d: expected error %v, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestEscalationLevel(t *testing.T) {
+	for step, expected := range map[int]struct {
+		level    int
+		priority database.Priority
+	}{
+		1: {LMod, database.PriorityHigh},
+		2: {LAdmin, database.PriorityCritical},
+		3: {LAdmin, database.PriorityCritical},
+	} {
+		if lvl, p := escalationLevel(step); lvl != expected.level || p != expected.priority {
+			t.Errorf("step %d: expected %v, got %d %v", step, expected, lvl, p)
+		}
+	}
+}
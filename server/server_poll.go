@@ -0,0 +1,255 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// validatePoll checks that a Poll has two or more distinct choices and valid options.
+func validatePoll(c *database.Content, now time.Time) error {
+	if c == nil || len(c.Choices) < 2 || !distinctChoices(c.Choices) {
+		return ErrBadPoll
+	}
+	if c.Poll == nil {
+		return nil
+	}
+	switch c.Poll.Results {
+	case "", database.ResultsPublic, database.ResultsVoters, database.ResultsPrivate:
+	default:
+		return ErrBadPoll
+	}
+	min, max := choiceRange(c.Poll)
+	if min < 1 || max < min || max > len(c.Choices) || c.Poll.ClosesAt != nil && !c.Poll.ClosesAt.After(now) {
+		return ErrBadPoll
+	}
+	return nil
+}
+
+// distinctChoices is true if all the choices have a different, non empty, value.
+func distinctChoices(choices []database.Choice) bool {
+	seen := make(map[string]bool, len(choices))
+	for _, v := range choices {
+		if v.Value == "" || seen[v.Value] {
+			return false
+		}
+		seen[v.Value] = true
+	}
+	return true
+}
+
+// choiceRange returns how many values a Vote of the Poll can have.
+func choiceRange(o *database.PollOptions) (min, max int) {
+	min, max = 1, 1
+	if o == nil {
+		return
+	}
+	if o.MinChoices != 0 {
+		min = o.MinChoices
+	}
+	if o.MaxChoices != 0 {
+		max = o.MaxChoices
+	}
+	return
+}
+
+// checkVote checks the values of a Vote against the Poll, a single value is moved to the values.
+func checkVote(poll, vote *database.Content) error {
+	if vote.Selected != "" {
+		if len(vote.Values) != 0 {
+			return ErrBadChoice
+		}
+		vote.Values, vote.Selected = []string{vote.Selected}, ""
+	}
+	if poll == nil {
+		return ErrBadChoice
+	}
+	if min, max := choiceRange(poll.Poll); len(vote.Values) < min || len(vote.Values) > max {
+		return ErrBadChoice
+	}
+	for i, v := range vote.Values {
+		if !hasChoice(poll, v) || contains(vote.Values[:i], v) {
+			return ErrBadChoice
+		}
+	}
+	return nil
+}
+
+// isClosed is true if a Poll was closed, or reached its closing time, before t.
+func isClosed(n *database.Notification, t time.Time) bool {
+	return n.ClosedAt != nil || n.ClosesAt != nil && !n.ClosesAt.After(t)
+}
+
+func pollClosesAt(c *database.Content) *time.Time {
+	if c == nil || c.Poll == nil {
+		return nil
+	}
+	return c.Poll.ClosesAt
+}
+
+func isAnonymous(c *database.Content) bool {
+	return c != nil && c.Poll != nil && c.Poll.Anonymous
+}
+
+func hasChoice(c *database.Content, value string) bool {
+	if c == nil || value == "" {
+		return false
+	}
+	for _, v := range c.Choices {
+		if v.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// saveVote saves a Vote, or replaces the previous Vote of the user on the same Poll.
+func (s *Server) saveVote(n *database.Notification) error {
+	v, err := database.GetVote(s.db, n.RefID, n.UserID)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		err = s.saveNotification(n)
+		if database.IsDuplicate(err) {
+			return ErrVoteConflict
+		}
+		return err
+	}
+	if err := s.editNotification(v, n.UserID, n.Content, false); err != nil {
+		return err
+	}
+	*n = *v
+	return nil
+}
+
+// GetNotification returns a Notification visible by the current user.
+func (s *Server) GetNotification(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, _, err := s.getVisibleNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, n)
+		return nil
+	})
+}
+
+// PollResults returns the number of Votes per choice of a Poll.
+// Besides its author and moderators, the results option of the Poll decides who can see them.
+func (s *Server) PollResults(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, lvl, err := s.getVisibleNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if n.Type != NPoll {
+			return ErrNotificationNotFound
+		}
+		if ok, err := s.canSeeResults(n, getUser(c), lvl); err != nil {
+			return err
+		} else if !ok {
+			return ErrUnauthorized
+		}
+		r, err := database.Tally(s.db, n)
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, r)
+		return nil
+	})
+}
+
+func (s *Server) canSeeResults(n *database.Notification, userID string, level int) (bool, error) {
+	if n.UserID == userID || level >= LMod {
+		return true, nil
+	}
+	switch resultsOption(n.Content) {
+	case database.ResultsPublic:
+		return true, nil
+	case database.ResultsVoters:
+		v, err := database.GetVote(s.db, n.ID, userID)
+		return v != nil, err
+	}
+	return false, nil
+}
+
+func resultsOption(c *database.Content) string {
+	if c == nil || c.Poll == nil || c.Poll.Results == "" {
+		return database.ResultsPublic
+	}
+	return c.Poll.Results
+}
+
+// ClosePoll closes a Poll before its closing time (author only).
+func (s *Server) ClosePoll(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		n, err := s.getEditableNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if n.Type != NPoll {
+			return ErrNotificationNotFound
+		}
+		if n.UserID != getUser(c) {
+			return ErrUnauthorized
+		}
+		if err := s.closePoll(n, time.Now()); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrPollClosed
+			}
+			return err
+		}
+		c.JSON(http.StatusOK, n)
+		return nil
+	})
+}
+
+// closePoll closes a Poll and sends its results, to the members that can see them.
+// The results of an anonymous Poll restricted to voters are sent only to its author,
+// to not disclose the voters.
+func (s *Server) closePoll(n *database.Notification, t time.Time) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	r := &database.Notification{
+		ID: newULID(), RoomID: n.RoomID, UserID: n.UserID, Priority: n.Priority, CreatedAt: t, Type: NResults,
+		RefID: n.ID, MinLevel: n.MinLevel, MaxLevel: n.MaxLevel,
+	}
+	defer func() {
+		closeTransaction(tx, &err)
+		if err == nil {
+			s.delivered(r)
+		}
+	}()
+	if err = database.ClosePoll(tx, n, t); err != nil {
+		return err
+	}
+	results, err := database.Tally(tx, n)
+	if err != nil {
+		return err
+	}
+	r.Content = &database.Content{RefID: n.ID, Results: results}
+	if n.Content != nil {
+		r.Content.Text = n.Content.Text
+	}
+	switch resultsOption(n.Content) {
+	case database.ResultsPublic:
+		r.Recipients, err = database.Recipients(tx, n.ID)
+	case database.ResultsVoters:
+		if !isAnonymous(n.Content) {
+			r.Recipients, err = database.Voters(tx, n.ID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(r.Recipients) == 0 && resultsOption(n.Content) != database.ResultsPublic {
+		r.Recipients = []string{n.UserID}
+	}
+	return s.insertNotification(tx, r)
+}
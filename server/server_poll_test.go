@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestValidatePoll(t *testing.T) {
+	choices := []database.Choice{{Label: "Yes", Value: "y"}, {Label: "No", Value: "n"}}
+	now := time.Now()
+	later := now.Add(time.Hour)
+	for i, tc := range []struct {
+		content *database.Content
+		err     error
+	}{
+		{nil, ErrBadPoll},
+		{&database.Content{Choices: choices[:1]}, ErrBadPoll},
+		{&database.Content{Choices: []database.Choice{{Value: "y"}, {Value: "y"}}}, ErrBadPoll},
+		{&database.Content{Choices: []database.Choice{{Value: "y"}, {Label: "No"}}}, ErrBadPoll},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{Results: "x"}}, ErrBadPoll},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{Results: database.ResultsVoters}}, nil},
+		{&database.Content{Choices: choices}, nil},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{MaxChoices: 2}}, nil},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{MaxChoices: 3}}, ErrBadPoll},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{MinChoices: 2}}, ErrBadPoll},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{ClosesAt: &now}}, ErrBadPoll},
+		{&database.Content{Choices: choices, Poll: &database.PollOptions{ClosesAt: &later}}, nil},
+	} {
+		if err := validatePoll(tc.content, now); err != tc.err {
+			t.Errorf("%d: expected error %v, got %v", i, tc.err, err)
+		}
+	}
+	if !hasChoice(&database.Content{Choices: choices}, "n") || hasChoice(&database.Content{Choices: choices}, "x") {
+		t.Error("unexpected hasChoice result")
+	}
+}
+
+func TestCheckVote(t *testing.T) {
+	poll := &database.Content{
+		Choices: []database.Choice{{Value: "a"}, {Value: "b"}, {Value: "c"}},
+		Poll:    &database.PollOptions{MinChoices: 1, MaxChoices: 2},
+	}
+	for i, tc := range []struct {
+		vote database.Content
+		err  error
+	}{
+		{database.Content{Selected: "a"}, nil},
+		{database.Content{Values: []string{"a", "c"}}, nil},
+		{database.Content{}, ErrBadChoice},
+		{database.Content{Values: []string{"a", "b", "c"}}, ErrBadChoice},
+		{database.Content{Values: []string{"a", "a"}}, ErrBadChoice},
+		{database.Content{Values: []string{"x"}}, ErrBadChoice},
+		{database.Content{Selected: "a", Values: []string{"b"}}, ErrBadChoice},
+	} {
+		if err := checkVote(poll, &tc.vote); err != tc.err {
+			t.Errorf("%d: expected error %v, got %v", i, tc.err, err)
+		}
+	}
+	vote := database.Content{Selected: "b"}
+	if checkVote(&database.Content{Choices: poll.Choices}, &vote); vote.Selected != "" || len(vote.Values) != 1 {
+		t.Errorf("expected single value in values, got %+v", vote)
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	now := time.Now()
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	for i, tc := range []struct {
+		n      database.Notification
+		closed bool
+	}{
+		{database.Notification{}, false},
+		{database.Notification{ClosesAt: &after}, false},
+		{database.Notification{ClosesAt: &before}, true},
+		{database.Notification{ClosesAt: &after, ClosedAt: &before}, true},
+	} {
+		if c := isClosed(&tc.n, now); c != tc.closed {
+			t.Errorf("%d: expected %v, got %v", i, tc.closed, c)
+		}
+	}
+}
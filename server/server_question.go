@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// validateQuestion checks that a Question with choice Answers has distinct choices.
+func validateQuestion(c *database.Content) error {
+	switch answersOption(c) {
+	case database.AnswersText:
+		return nil
+	case database.AnswersChoices:
+		if len(c.Choices) != 0 && distinctChoices(c.Choices) {
+			return nil
+		}
+	}
+	return ErrBadQuestion
+}
+
+func answersOption(c *database.Content) string {
+	if c == nil || c.Question == nil || c.Question.Answers == "" {
+		return database.AnswersText
+	}
+	return c.Question.Answers
+}
+
+// QuestionAnswers returns a page of the Answers to a Question.
+// The author of the Question and moderators see every Answer, other users only their own.
+func (s *Server) QuestionAnswers(id string) gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		page, err := getPage(c)
+		if err != nil {
+			return err
+		}
+		if page.ByPriority {
+			return ErrBadPage
+		}
+		q, _, err := s.getVisibleNotification(c, c.Param(id))
+		if err != nil {
+			return err
+		}
+		if q.Type != NQuestion {
+			return ErrNotificationNotFound
+		}
+		levels, err := s.getLevels(c)
+		if err != nil {
+			return err
+		}
+		f := database.NotificationFilter{
+			UserID:     getUser(c),
+			Levels:     levels,
+			Rules:      rulesView,
+			Superseded: true,
+			Type:       NAnswer,
+			RefID:      q.ID,
+		}
+		page.Limit++
+		list, err := database.ListNotifications(s.db, f, page)
+		if err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, newPage(list, page))
+		return nil
+	})
+}
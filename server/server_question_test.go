@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestValidateQuestion(t *testing.T) {
+	choices := &database.QuestionOptions{Answers: database.AnswersChoices}
+	for i, tc := range []struct {
+		content *database.Content
+		err     error
+	}{
+		{nil, nil},
+		{&database.Content{Text: "?"}, nil},
+		{&database.Content{Question: &database.QuestionOptions{Answers: database.AnswersText}}, nil},
+		{&database.Content{Question: &database.QuestionOptions{Answers: "x"}}, ErrBadQuestion},
+		{&database.Content{Question: choices}, ErrBadQuestion},
+		{&database.Content{Question: choices, Choices: []database.Choice{{Value: "a"}, {Value: "a"}}}, ErrBadQuestion},
+		{&database.Content{Question: choices, Choices: []database.Choice{{Value: "a"}}}, nil},
+	} {
+		if err := validateQuestion(tc.content); err != tc.err {
+			t.Errorf("%d: expected error %v, got %v", i, tc.err, err)
+		}
+	}
+}
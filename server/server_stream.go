@@ -0,0 +1,162 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// streamHeartbeat is the interval of the keep-alive messages of a stream.
+const streamHeartbeat = 15 * time.Second
+
+// streamWindow is how long before the last notification sent a stream looks for notifications committed late.
+const streamWindow = time.Minute
+
+// cursor is the position of a stream. IDs are assigned before commit, so a notification can be visible
+// after one with a greater ID: the stream lists again a window before the last ID, skipping the ones sent.
+// The notifications before the first position are never listed again.
+type cursor struct {
+	first, last string
+	sent        map[string]bool
+}
+
+func newCursor(from string) *cursor {
+	return &cursor{first: from, last: from, sent: make(map[string]bool)}
+}
+
+// from returns the ID to list from.
+func (c *cursor) from() string {
+	id, err := ulid.Parse(c.last)
+	if err != nil {
+		return c.last
+	}
+	if w := ulidAt(ulid.Time(id.Time()).Add(-streamWindow)); w > c.first {
+		return w
+	}
+	return c.first
+}
+
+// next drops the notifications already sent and moves after the others.
+func (c *cursor) next(list []*database.Notification) []*database.Notification {
+	result := list[:0:0]
+	for _, n := range list {
+		if c.sent[n.ID] {
+			continue
+		}
+		c.sent[n.ID] = true
+		if n.ID > c.last {
+			c.last = n.ID
+		}
+		result = append(result, n)
+	}
+	from := c.from()
+	for id := range c.sent {
+		if id <= from {
+			delete(c.sent, id)
+		}
+	}
+	return result
+}
+
+// StreamNotifications sends the new notifications of the current user as Server-Sent Events.
+// The stream resumes after the Last-Event-ID header or the from parameter, if present.
+func (s *Server) StreamNotifications() gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		levels, err := s.getLevels(c)
+		if err != nil {
+			return err
+		}
+		from := c.GetHeader("Last-Event-ID")
+		if from == "" {
+			from = c.Query("from")
+		}
+		if from == "" {
+			from = ulidAt(time.Now())
+		}
+		l := s.hub.listen(roomIDs(levels))
+		defer s.hub.close(l)
+
+		h := c.Writer.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		h.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+
+		cur := newCursor(from)
+		t := time.NewTicker(streamHeartbeat)
+		defer t.Stop()
+		for wake := true; ; {
+			if wake {
+				list, err := s.notificationsAfter(getUser(c), levels, cur.from())
+				if err != nil {
+					log.Println("Stream error:", err)
+					return nil
+				}
+				for _, n := range cur.next(list) {
+					if err := sse.Encode(c.Writer, sse.Event{Id: n.ID, Event: "notification", Data: n}); err != nil {
+						return nil
+					}
+				}
+			}
+			c.Writer.Flush()
+			select {
+			case <-c.Request.Context().Done():
+				return nil
+			case <-s.ctx.Done():
+				return nil
+			case <-l.wake:
+				wake = true
+			case <-t.C:
+				if _, err := io.WriteString(c.Writer, ":\n\n"); err != nil {
+					return nil
+				}
+				if levels, err = s.getLevels(c); err != nil {
+					log.Println("Stream error:", err)
+					return nil
+				}
+				s.hub.setRooms(l, roomIDs(levels))
+				wake = false
+			}
+		}
+	})
+}
+
+// notificationsAfter returns all the notifications visible by the user with an ID greater than from.
+func (s *Server) notificationsAfter(userID string, levels map[string]int, from string) ([]*database.Notification, error) {
+	var result []*database.Notification
+	for {
+		list, err := database.ListNotifications(s.db, database.NotificationFilter{
+			UserID: userID, Levels: levels, Rules: rulesView,
+		}, database.Page{From: from, Limit: maxPageLimit})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, list...)
+		if len(list) < maxPageLimit {
+			s.trackDelivery(userID, result)
+			return result, nil
+		}
+		from = list[len(list)-1].ID
+	}
+}
+
+// ulidAt returns the lowest ULID for the time, to select the IDs created after it.
+func ulidAt(t time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(t), nil).String()
+}
+
+func roomIDs(levels map[string]int) []string {
+	rooms := make([]string, 0, len(levels))
+	for id := range levels {
+		rooms = append(rooms, id)
+	}
+	return rooms
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestCursor(t *testing.T) {
+	now := time.Now()
+	id := func(d time.Duration) string { return ulidAt(now.Add(d)) }
+	list := func(ids ...string) []*database.Notification {
+		l := make([]*database.Notification, len(ids))
+		for i, id := range ids {
+			l[i] = &database.Notification{ID: id}
+		}
+		return l
+	}
+	c := newCursor(id(-time.Hour))
+	if from := c.from(); from != id(-time.Hour) {
+		t.Errorf("expected the first position, got %s", from)
+	}
+	if got := c.next(list(id(-2*time.Second), id(0))); len(got) != 2 {
+		t.Errorf("expected 2 notifications, got %d", len(got))
+	}
+	if from := c.from(); from != id(-streamWindow) {
+		t.Errorf("expected the window before the last ID, got %s", from)
+	}
+	// a notification committed late is sent, the others are not sent again
+	got := c.next(list(id(-2*time.Second), id(-time.Second), id(0)))
+	if len(got) != 1 || got[0].ID != id(-time.Second) {
+		t.Errorf("expected the late notification, got %v", got)
+	}
+}
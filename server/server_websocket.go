@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+// Types of WebSocket messages.
+const (
+	wsNotification = "notification" // server: new notification
+	wsResult       = "result"       // server: result of a client message
+	wsRead         = "read"         // client: marks a notification as read, or "all"
+	wsUnread       = "unread"       // client: marks a notification as unread
+)
+
+// wsMessage is a message of the notification WebSocket.
+// Clients can also send NVote and NAnswer messages with a notification.
+type wsMessage struct {
+	Type           string                 `json:"type"`
+	ID             string                 `json:"id,omitempty"`
+	NotificationID string                 `json:"notification_id,omitempty"`
+	Notification   *database.Notification `json:"notification,omitempty"`
+	Error          *ErrorResponse         `json:"error,omitempty"`
+}
+
+// NotificationSocket sends the new notifications of the current user through a WebSocket,
+// and handles the client messages. The user is authenticated at upgrade time.
+func (s *Server) NotificationSocket() gin.HandlerFunc {
+	return handler(func(c *gin.Context) error {
+		levels, err := s.getLevels(c)
+		if err != nil {
+			return err
+		}
+		from := c.Query("from")
+		if from == "" {
+			from = ulidAt(time.Now())
+		}
+		ws, err := upgradeWebSocket(c.Writer, c.Request, s.origins)
+		switch err {
+		case errBadUpgrade:
+			return ErrBadUpgrade
+		case errBadOrigin:
+			return ErrBadOrigin
+		}
+		if err != nil {
+			return err
+		}
+		defer ws.close()
+		l := s.hub.listen(roomIDs(levels))
+		defer s.hub.close(l)
+
+		in, done := make(chan wsMessage), make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(in)
+			for {
+				b, err := ws.readMessage()
+				if err != nil {
+					return
+				}
+				var m wsMessage
+				if err := json.Unmarshal(b, &m); err != nil {
+					m = wsMessage{}
+				}
+				select {
+				case in <- m:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		s.pushNotifications(ws, l, in, from, func(from string) ([]*database.Notification, error) {
+			return s.notificationsAfter(getUser(c), levels, from)
+		}, func(m *wsMessage) wsMessage {
+			return s.handleMessage(c, m)
+		}, func() error {
+			if levels, err = s.getLevels(c); err != nil {
+				return err
+			}
+			s.hub.setRooms(l, roomIDs(levels))
+			return nil
+		})
+		return nil
+	})
+}
+
+// pushNotifications sends the notifications returned by fetch when the listener wakes up, and the results
+// of the client messages, until the connection or the server is closed. The rooms are refreshed at every heartbeat.
+func (s *Server) pushNotifications(ws *wsConn, l *listener, in <-chan wsMessage, from string,
+	fetch func(from string) ([]*database.Notification, error), handle func(*wsMessage) wsMessage, refresh func() error) {
+	cur := newCursor(from)
+	t := time.NewTicker(streamHeartbeat)
+	defer t.Stop()
+	for wake := true; ; {
+		if wake {
+			list, err := fetch(cur.from())
+			if err != nil {
+				log.Println("WebSocket error:", err)
+				return
+			}
+			for _, n := range cur.next(list) {
+				if err := ws.writeJSON(wsMessage{Type: wsNotification, Notification: n}); err != nil {
+					return
+				}
+			}
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+			if err := ws.writeJSON(handle(&m)); err != nil {
+				return
+			}
+			wake = false
+		case <-l.wake:
+			wake = true
+		case <-t.C:
+			if err := ws.ping(); err != nil {
+				return
+			}
+			if err := refresh(); err != nil {
+				log.Println("WebSocket error:", err)
+				return
+			}
+			wake = false
+		}
+	}
+}
+
+// handleMessage executes a client message and returns its result.
+func (s *Server) handleMessage(c *gin.Context, m *wsMessage) wsMessage {
+	r := wsMessage{Type: wsResult, ID: m.ID}
+	var err error
+	switch m.Type {
+	case wsRead:
+		err = s.readNotification(c, m.NotificationID)
+	case wsUnread:
+		err = s.unreadNotification(c, m.NotificationID)
+	case NVote, NAnswer:
+		if m.Notification == nil {
+			err = ErrBadJSON
+			break
+		}
+		m.Notification.Type = m.Type
+		if err = s.postNotification(c, m.Notification); err == nil {
+			r.NotificationID = m.Notification.ID
+		}
+	default:
+		err = ErrUnknownMessage
+	}
+	if err != nil {
+		e, ok := err.(ErrorResponse)
+		if !ok {
+			log.Println("WebSocket error:", err)
+			e = ErrorResponse{http.StatusInternalServerError, "UNKNOWN", err.Error()}
+		}
+		r.Error = &e
+	}
+	return r
+}
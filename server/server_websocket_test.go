@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/securityfirst/matrix-notifier/database"
+)
+
+func TestPushNotifications(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{hub: newHub(), ctx: ctx}
+	l := s.hub.listen([]string{"!room"})
+
+	var mu sync.Mutex
+	var list []*database.Notification
+	fetched := make(chan struct{}, 10)
+	fetch := func(from string) ([]*database.Notification, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		defer func() { fetched <- struct{}{} }()
+		var result []*database.Notification
+		for _, n := range list {
+			if n.ID > from {
+				result = append(result, n)
+			}
+		}
+		return result, nil
+	}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		ws, err := upgradeWebSocket(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.close()
+		s.pushNotifications(ws, l, nil, "0", fetch, nil, nil)
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	<-fetched
+	mu.Lock()
+	list = append(list, &database.Notification{ID: "1", RoomID: "!room"})
+	mu.Unlock()
+	s.hub.publish("!room")
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var m wsMessage
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal("expected a message after publish:", err)
+	}
+	if m.Type != wsNotification || m.Notification == nil || m.Notification.ID != "1" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+// Default values for the token cache.
+const (
+	DefaultTokenTTL        = 5 * time.Minute
+	DefaultInvalidTokenTTL = 30 * time.Second
+	DefaultTokenCacheSize  = 10000
+)
+
+// tokenEntry is the result of an access token validation.
+type tokenEntry struct {
+	token   string
+	userID  string // empty if the token is invalid
+	client  *gomatrix.Client
+	expires time.Time
+}
+
+func (e *tokenEntry) valid() bool { return e.userID != "" }
+
+// tokenCache is a size bound LRU cache of validated access tokens.
+type tokenCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	invalidTTL time.Duration
+	size       int
+	entries    map[string]*list.Element
+	order      *list.List // most recently used first
+}
+
+func newTokenCache(ttl, invalidTTL time.Duration, size int) *tokenCache {
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	if invalidTTL <= 0 {
+		invalidTTL = DefaultInvalidTokenTTL
+	}
+	if size <= 0 {
+		size = DefaultTokenCacheSize
+	}
+	return &tokenCache{
+		ttl:        ttl,
+		invalidTTL: invalidTTL,
+		size:       size,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the entry for the token, if present and not expired.
+func (t *tokenCache) get(token string) (*tokenEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.entries[token]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*tokenEntry)
+	if time.Now().After(e.expires) {
+		t.remove(el)
+		return nil, false
+	}
+	t.order.MoveToFront(el)
+	return e, true
+}
+
+// set saves a valid token for a user.
+func (t *tokenCache) set(token, userID string, client *gomatrix.Client) *tokenEntry {
+	return t.add(&tokenEntry{token: token, userID: userID, client: client, expires: time.Now().Add(t.ttl)})
+}
+
+// setInvalid saves an invalid token.
+func (t *tokenCache) setInvalid(token string) *tokenEntry {
+	return t.add(&tokenEntry{token: token, expires: time.Now().Add(t.invalidTTL)})
+}
+
+func (t *tokenCache) add(e *tokenEntry) *tokenEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[e.token]; ok {
+		t.remove(el)
+	}
+	t.entries[e.token] = t.order.PushFront(e)
+	for t.order.Len() > t.size {
+		t.remove(t.order.Back())
+	}
+	return e
+}
+
+// evict removes a token from the cache.
+func (t *tokenCache) evict(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[token]; ok {
+		t.remove(el)
+	}
+}
+
+func (t *tokenCache) remove(el *list.Element) {
+	delete(t.entries, el.Value.(*tokenEntry).token)
+	t.order.Remove(el)
+}
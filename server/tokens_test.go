@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenCache(t *testing.T) {
+	c := newTokenCache(time.Hour, time.Hour, 2)
+	c.set("a", "@a:server", nil)
+	c.set("b", "@b:server", nil)
+	c.setInvalid("x")
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected a to be dropped by size")
+	}
+	if e, ok := c.get("b"); !ok || !e.valid() || e.userID != "@b:server" {
+		t.Fatalf("unexpected entry for b: %#v", e)
+	}
+	if e, ok := c.get("x"); !ok || e.valid() {
+		t.Fatalf("unexpected entry for x: %#v", e)
+	}
+	c.evict("b")
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	c := newTokenCache(time.Millisecond, time.Hour, 0)
+	c.set("a", "@a:server", nil)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected a to be expired")
+	}
+	if l := c.order.Len(); l != 0 {
+		t.Fatalf("expected empty cache, got %d", l)
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	for _, tc := range []struct {
+		header, query string
+		token         string
+		err           error
+	}{
+		{"Bearer abc", "", "abc", nil},
+		{"bearer  abc ", "", "abc", nil},
+		{"Bearer ", "", "", ErrMissingToken},
+		{"Bearer", "", "", ErrMissingToken},
+		{"Basic abc", "", "", ErrBadAuthorization},
+		{"", "access_token=abc", "abc", nil},
+		{"", "", "", ErrMissingToken},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+tc.query, nil)
+		if tc.header != "" {
+			c.Request.Header.Set("Authorization", tc.header)
+		}
+		token, err := (&Server{}).getToken(c)
+		if token != tc.token || err != tc.err {
+			t.Errorf("%q: expected %q %v, got %q %v", tc.header, tc.token, tc.err, token, err)
+		}
+	}
+}
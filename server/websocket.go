@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket limits, a client that does not answer to pings or read messages in time is disconnected.
+const (
+	wsMaxMessage = 1 << 16
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 2 * streamHeartbeat // pings are sent at every heartbeat
+)
+
+var (
+	errBadUpgrade = errors.New("websocket: bad upgrade request")
+	errBadOrigin  = errors.New("websocket: origin not allowed")
+)
+
+// wsConn is a WebSocket connection, its writes are safe for concurrent use.
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+// wsUpgrader leaves the checks and the error responses to upgradeWebSocket.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+	Error:       func(http.ResponseWriter, *http.Request, int, error) {},
+}
+
+// checkOrigin accepts requests without an Origin (not from a browser),
+// from the same host, or from one of the allowed origins.
+func checkOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, v := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(v, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection.
+// Browser requests are accepted only from the same host or the allowed origins.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, origins []string) (*wsConn, error) {
+	if r.Method != http.MethodGet || !websocket.IsWebSocketUpgrade(r) ||
+		r.Header.Get("Sec-WebSocket-Key") == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errBadUpgrade
+	}
+	if !checkOrigin(r, origins) {
+		return nil, errBadOrigin
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newWSConn(conn), nil
+}
+
+// newWSConn sets the limits of a connection, the read deadline is extended by pongs and messages.
+func newWSConn(conn *websocket.Conn) *wsConn {
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	return &wsConn{conn: conn}
+}
+
+// readMessage returns the next data message, control frames are handled while reading.
+func (ws *wsConn) readMessage() ([]byte, error) {
+	_, msg, err := ws.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return msg, ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+}
+
+// writeJSON sends v as a text message.
+func (ws *wsConn) writeJSON(v interface{}) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if err := ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		return err
+	}
+	return ws.conn.WriteJSON(v)
+}
+
+// ping sends a ping, the client must answer before the read deadline.
+func (ws *wsConn) ping() error {
+	return ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+}
+
+// close sends a normal closure frame and closes the connection.
+func (ws *wsConn) close() error {
+	ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(wsWriteWait))
+	return ws.conn.Close()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := []string{"https://app.example.org/"}
+	for i, tc := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://notifier.example.org", true},
+		{"https://app.example.org", true},
+		{"https://evil.example.org", false},
+		{"null", false},
+	} {
+		r := &http.Request{Host: "notifier.example.org", Header: http.Header{}}
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if ok := checkOrigin(r, allowed); ok != tc.ok {
+			t.Errorf("%d: expected %v for %q, got %v", i, tc.ok, tc.origin, ok)
+		}
+	}
+}
+
+// wsServer echoes the messages of a WebSocket, it answers 400 or 403 to bad upgrades.
+func wsServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgradeWebSocket(w, r, nil)
+		switch err {
+		case nil:
+		case errBadUpgrade:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case errBadOrigin:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		default:
+			t.Error(err)
+			return
+		}
+		defer ws.close()
+		for {
+			msg, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.writeJSON(string(msg)); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	srv := wsServer(t)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if resp, err := http.Get(srv.URL); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad upgrade, got %v %v", resp, err)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.org"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected bad origin, got %v %v", resp, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var msg string
+	if err := conn.ReadJSON(&msg); err != nil || msg != "hello" {
+		t.Errorf("expected echo, got %q %v", msg, err)
+	}
+	big := make([]byte, wsMaxMessage+1)
+	if err := conn.WriteMessage(websocket.TextMessage, big); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("expected message too big, got %v", err)
+	}
+}